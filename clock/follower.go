@@ -0,0 +1,374 @@
+package clock
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/minikomi/rtmididrv"
+)
+
+// State is the transport state of a follower.
+type State int
+
+const (
+	// Stopped is the state before start and after stop.
+	Stopped State = iota
+	// Playing is the state after start and continue.
+	Playing
+)
+
+func (s State) String() string {
+	if s == Playing {
+		return "playing"
+	}
+	return "stopped"
+}
+
+// EventType is the type of an event of a follower.
+type EventType int
+
+const (
+	// EventTempo is sent when the estimated tempo has changed by at least the tempo threshold.
+	EventTempo EventType = iota
+	// EventStart is sent for a start message.
+	EventStart
+	// EventContinue is sent for a continue message.
+	EventContinue
+	// EventStop is sent for a stop message.
+	EventStop
+	// EventSongPosition is sent for a song position pointer.
+	EventSongPosition
+	// EventClockLost is sent when no clock pulse has arrived within the timeout.
+	EventClockLost
+	// EventClockResumed is sent for the first clock pulse after the clock has been lost.
+	EventClockResumed
+)
+
+var eventTypeNames = [...]string{"tempo", "start", "continue", "stop", "song_position", "clock_lost", "clock_resumed"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventTypeNames[t]
+}
+
+// Event is an event of a follower.
+type Event struct {
+	Type EventType
+	// BPM is the estimated tempo, 0 if it is unknown
+	BPM float64
+	// Position is the song position in clock pulses
+	Position uint64
+	State    State
+}
+
+// Follower follows the MIDI clock and the transport messages of an external master.
+//
+// The tempo is estimated from the average interval of the last clock pulses (see Window), measured with
+// the timestamps of the messages. Tempo changes are reported when the intervals of the window differ by
+// no more than 25%, so that a jump of the tempo is reported once the window has caught up.
+//
+// The song position counts the clock pulses while playing; it is set by start (to 0) and by song position pointers.
+type Follower struct {
+	in rtmididrv.In
+
+	mx          sync.Mutex
+	handler     func(Event)
+	timeout     time.Duration
+	window      int
+	threshold   float64
+	beatsPerBar int
+
+	state    State
+	position uint64
+	// current is the position of the pulse that has been played last
+	current uint64
+
+	// intervals are the last intervals between the clock pulses in microseconds, sum their sum
+	intervals []int64
+	sum       int64
+	lastPulse int64
+	pulsed    bool
+	reported  float64
+
+	lost      bool
+	lostTimer *time.Timer
+	lastWall  time.Time
+	closed    bool
+}
+
+// FollowerOption is an option for a follower.
+type FollowerOption func(*Follower)
+
+// Timeout sets the time without clock pulses after which the clock is considered lost. The default is 500ms.
+func Timeout(d time.Duration) FollowerOption {
+	return func(f *Follower) {
+		f.timeout = d
+	}
+}
+
+// Window sets the number of pulse intervals that the tempo is averaged over. The default is 24 (a quarter note).
+func Window(pulses int) FollowerOption {
+	return func(f *Follower) {
+		if pulses > 0 {
+			f.window = pulses
+		}
+	}
+}
+
+// TempoThreshold sets the change of the estimated tempo in BPM that triggers an EventTempo. The default is 0.5.
+func TempoThreshold(bpm float64) FollowerOption {
+	return func(f *Follower) {
+		f.threshold = bpm
+	}
+}
+
+// BeatsPerBar sets the number of quarter notes per bar for BarBeat. The default is 4.
+func BeatsPerBar(beats int) FollowerOption {
+	return func(f *Follower) {
+		if beats > 0 {
+			f.beatsPerBar = beats
+		}
+	}
+}
+
+// EventHandler sets the function that gets the events of the follower. It is called without locks held,
+// by the goroutine of the in port or, for EventClockLost, by a timer goroutine.
+func EventHandler(handler func(Event)) FollowerOption {
+	return func(f *Follower) {
+		f.handler = handler
+	}
+}
+
+// NewFollower returns a follower of the clock that arrives at the in port. The port is opened, if it is not open,
+// and the follower sets its listener. To get clock messages, the driver must not ignore them
+// (see rtmididrv.IgnoreTypes).
+func NewFollower(in rtmididrv.In, options ...FollowerOption) (*Follower, error) {
+	f := newFollower(options...)
+	f.in = in
+
+	if !in.IsOpen() {
+		err := in.Open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := in.SetTimestampedListener(func(data []byte, timestampMicroseconds, _ int64) {
+		f.Handle(data, timestampMicroseconds)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func newFollower(options ...FollowerOption) *Follower {
+	f := &Follower{
+		timeout:     500 * time.Millisecond,
+		window:      PPQN,
+		threshold:   0.5,
+		beatsPerBar: 4,
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
+}
+
+// Handle processes a message with the given timestamp. It is called by the listener of the in port;
+// call it to feed the follower from other sources.
+func (f *Follower) Handle(msg []byte, timestampMicroseconds int64) {
+	if len(msg) == 0 {
+		return
+	}
+
+	var events []Event
+
+	f.mx.Lock()
+	if f.closed {
+		f.mx.Unlock()
+		return
+	}
+
+	switch msg[0] {
+	case msgClock:
+		events = f.pulse(timestampMicroseconds, events)
+	case msgStart:
+		f.state = Playing
+		f.position = 0
+		f.current = 0
+		events = append(events, f.event(EventStart))
+	case msgContinue:
+		f.state = Playing
+		events = append(events, f.event(EventContinue))
+	case msgStop:
+		f.state = Stopped
+		events = append(events, f.event(EventStop))
+	case msgSongPos:
+		if len(msg) == 3 {
+			f.position = (uint64(msg[2])<<7 | uint64(msg[1])) * 6
+			f.current = f.position
+			events = append(events, f.event(EventSongPosition))
+		}
+	}
+	handler := f.handler
+	f.mx.Unlock()
+
+	if handler != nil {
+		for _, ev := range events {
+			handler(ev)
+		}
+	}
+}
+
+// pulse handles a clock pulse. It must be called with the lock held.
+func (f *Follower) pulse(ts int64, events []Event) []Event {
+	f.lastWall = time.Now()
+	if f.lostTimer == nil {
+		f.lostTimer = time.AfterFunc(f.timeout, f.checkLost)
+	} else {
+		f.lostTimer.Reset(f.timeout)
+	}
+
+	if f.lost {
+		f.lost = false
+		events = append(events, f.event(EventClockResumed))
+	}
+
+	if f.state == Playing {
+		f.current = f.position
+		f.position++
+	}
+
+	if f.pulsed {
+		d := ts - f.lastPulse
+		if d > 0 && d < int64(f.timeout/time.Microsecond) {
+			f.intervals = append(f.intervals, d)
+			f.sum += d
+			if len(f.intervals) > f.window {
+				f.sum -= f.intervals[0]
+				f.intervals = f.intervals[1:]
+			}
+		}
+	}
+	f.pulsed = true
+	f.lastPulse = ts
+
+	if bpm := f.bpm(); f.settled() && math.Abs(bpm-f.reported) >= f.threshold {
+		f.reported = bpm
+		events = append(events, f.event(EventTempo))
+	}
+	return events
+}
+
+// checkLost is called by the timer when no pulse has arrived within the timeout.
+func (f *Follower) checkLost() {
+	f.mx.Lock()
+	if f.closed || f.lost || time.Since(f.lastWall) < f.timeout {
+		f.mx.Unlock()
+		return
+	}
+
+	f.lost = true
+	f.pulsed = false
+	f.intervals = nil
+	f.sum = 0
+	f.reported = 0
+	ev := f.event(EventClockLost)
+	handler := f.handler
+	f.mx.Unlock()
+
+	if handler != nil {
+		handler(ev)
+	}
+}
+
+// settled reports whether the window is full and its intervals are close to each other, so that
+// a tempo change is reported when the new tempo has been reached and not while the average moves towards it.
+// It must be called with the lock held.
+func (f *Follower) settled() bool {
+	if len(f.intervals) == 0 || len(f.intervals) < f.window {
+		return false
+	}
+
+	min, max := f.intervals[0], f.intervals[0]
+	for _, d := range f.intervals {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	return max*4 <= min*5
+}
+
+// bpm returns the estimated tempo. It must be called with the lock held.
+func (f *Follower) bpm() float64 {
+	if len(f.intervals) == 0 {
+		return 0
+	}
+	avg := float64(f.sum) / float64(len(f.intervals))
+	return 60e6 / (avg * PPQN)
+}
+
+// event returns an event with the current state. It must be called with the lock held.
+func (f *Follower) event(typ EventType) Event {
+	return Event{Type: typ, BPM: f.bpm(), Position: f.position, State: f.state}
+}
+
+// Tempo returns the estimated tempo in beats per minute, or 0 if it is unknown.
+func (f *Follower) Tempo() float64 {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.bpm()
+}
+
+// State returns the transport state.
+func (f *Follower) State() State {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.state
+}
+
+// Position returns the song position in clock pulses, i.e. the position of the next pulse.
+func (f *Follower) Position() uint64 {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.position
+}
+
+// BarBeat returns the bar and the beat (quarter note) of the pulse that has been played last, both counted from 1.
+func (f *Follower) BarBeat() (bar, beat int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	beats := int(f.current / PPQN)
+	return beats/f.beatsPerBar + 1, beats%f.beatsPerBar + 1
+}
+
+// ClockLost reports whether the clock has been lost.
+func (f *Follower) ClockLost() bool {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.lost
+}
+
+// Close stops listening to the in port. The port is not closed.
+func (f *Follower) Close() error {
+	f.mx.Lock()
+	f.closed = true
+	if f.lostTimer != nil {
+		f.lostTimer.Stop()
+	}
+	f.mx.Unlock()
+
+	if f.in == nil {
+		return nil
+	}
+	return f.in.StopListening()
+}
@@ -0,0 +1,119 @@
+package clock
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testEvents struct {
+	sync.Mutex
+	events []Event
+}
+
+func (e *testEvents) handle(ev Event) {
+	e.Lock()
+	defer e.Unlock()
+	e.events = append(e.events, ev)
+}
+
+func (e *testEvents) types() []EventType {
+	e.Lock()
+	defer e.Unlock()
+	types := make([]EventType, len(e.events))
+	for i, ev := range e.events {
+		types[i] = ev.Type
+	}
+	return types
+}
+
+func TestFollower(t *testing.T) {
+	var events testEvents
+	f := newFollower(EventHandler(events.handle), Timeout(time.Hour), BeatsPerBar(3))
+	defer f.Close()
+
+	// 120 BPM: a pulse every 20833us
+	var ts int64
+	pulses := func(n int, interval int64) {
+		for i := 0; i < n; i++ {
+			ts += interval
+			f.Handle([]byte{msgClock}, ts)
+		}
+	}
+
+	pulses(10, 20833)
+	if f.State() != Stopped || f.Position() != 0 {
+		t.Errorf("state %v, position %v before start", f.State(), f.Position())
+	}
+	if bpm := f.Tempo(); math.Abs(bpm-120) > 0.1 {
+		t.Errorf("tempo %v, want 120", bpm)
+	}
+
+	f.Handle([]byte{msgStart}, ts)
+	pulses(24*4, 20833)
+
+	if f.State() != Playing || f.Position() != 96 {
+		t.Errorf("state %v, position %v after 4 beats", f.State(), f.Position())
+	}
+	if bar, beat := f.BarBeat(); bar != 2 || beat != 1 {
+		t.Errorf("at %v.%v, want 2.1", bar, beat)
+	}
+
+	// 60 BPM
+	pulses(48, 41667)
+	if bpm := f.Tempo(); math.Abs(bpm-60) > 0.1 {
+		t.Errorf("tempo %v, want 60", bpm)
+	}
+
+	f.Handle([]byte{msgStop}, ts)
+	f.Handle([]byte{msgSongPos, 0x10, 0x01}, ts)
+	if f.Position() != (128+16)*6 {
+		t.Errorf("position %v after song position pointer", f.Position())
+	}
+	pulses(3, 41667)
+	f.Handle([]byte{msgContinue}, ts)
+	pulses(1, 41667)
+	if f.Position() != (128+16)*6+1 {
+		t.Errorf("position %v after continue", f.Position())
+	}
+
+	want := []EventType{EventStart, EventTempo, EventTempo, EventStop, EventSongPosition, EventContinue}
+	got := events.types()
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %v: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFollowerClockLost(t *testing.T) {
+	var events testEvents
+	f := newFollower(EventHandler(events.handle), Timeout(30*time.Millisecond))
+	defer f.Close()
+
+	f.Handle([]byte{msgClock}, 0)
+	f.Handle([]byte{msgClock}, 20000)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !f.ClockLost() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !f.ClockLost() || f.Tempo() != 0 {
+		t.Fatalf("clock not lost: tempo %v", f.Tempo())
+	}
+
+	f.Handle([]byte{msgClock}, 1000000)
+	if f.ClockLost() {
+		t.Errorf("clock still lost")
+	}
+
+	got := events.types()
+	if len(got) != 2 || got[0] != EventClockLost || got[1] != EventClockResumed {
+		t.Errorf("got events %v", got)
+	}
+}
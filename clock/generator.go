@@ -0,0 +1,427 @@
+// Package clock sends and follows MIDI clock (24 pulses per quarter note) with the transport messages
+// start, stop, continue and song position pointer.
+package clock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Out is the part of an out port that the generator uses. It is implemented by rtmididrv.Out.
+type Out interface {
+	Send([]byte) error
+}
+
+// MIDI clock and transport messages.
+const (
+	msgClock    = 0xF8
+	msgStart    = 0xFA
+	msgContinue = 0xFB
+	msgStop     = 0xFC
+	msgSongPos  = 0xF2
+)
+
+// PPQN is the number of clock pulses per quarter note.
+const PPQN = 24
+
+// Generator is a MIDI clock master. While it is running, it sends clock pulses to its outs.
+//
+// Every pulse is scheduled at its absolute time on a timeline that starts with Start or Continue, so that
+// delays of single pulses do not add up. The time of a pulse is computed from the time of the last tempo change,
+// so that the rounding of the pulse intervals does not add up either.
+//
+// Every out can have a latency offset: the pulses (and start and continue) are sent that much later, or with
+// a negative offset earlier, than on the timeline, e.g. to compensate for the latency of a device.
+type Generator struct {
+	mx           sync.Mutex
+	outs         []*output
+	bpm          float64
+	ramp         *ramp
+	errorHandler func(error)
+
+	// position is the song position in pulses at the start of the timeline
+	position uint64
+
+	running bool
+	// stop is nil while the generator is stopping
+	stop chan struct{}
+	done chan struct{}
+
+	// times are the times of the pulses base, base+1, ... of the timeline that have been computed
+	times []time.Time
+	base  uint64
+	// the pulses after anchorTick follow anchorTime with the interval of bpm
+	anchorTime time.Time
+	anchorTick uint64
+}
+
+type output struct {
+	out    Out
+	offset time.Duration
+	// next is the next pulse of the timeline to send
+	next uint64
+	// transport is the start or continue message to send before the next pulse, or 0
+	transport byte
+}
+
+// ramp changes the tempo linearly over time.
+type ramp struct {
+	from, to float64
+	start    time.Time
+	duration time.Duration
+}
+
+// NewGenerator returns a stopped generator with the given tempo in beats (quarter notes) per minute.
+func NewGenerator(bpm float64) (*Generator, error) {
+	if bpm <= 0 {
+		return nil, fmt.Errorf("invalid tempo %v", bpm)
+	}
+	return &Generator{bpm: bpm}, nil
+}
+
+// AddOut adds an out with the given latency offset. Outs can't be added while the generator is running.
+func (g *Generator) AddOut(out Out, offset time.Duration) error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.running {
+		return fmt.Errorf("can't add out: clock is running")
+	}
+	g.outs = append(g.outs, &output{out: out, offset: offset})
+	return nil
+}
+
+// SetErrorHandler sets a function that is called with the errors of sending messages.
+func (g *Generator) SetErrorHandler(handler func(error)) {
+	g.mx.Lock()
+	g.errorHandler = handler
+	g.mx.Unlock()
+}
+
+// interval returns the time between two pulses at the given tempo.
+func interval(bpm float64) float64 {
+	return float64(time.Minute) / (bpm * PPQN)
+}
+
+// bpmAt returns the tempo at the given time. It must be called with the lock held.
+func (g *Generator) bpmAt(t time.Time) float64 {
+	if g.ramp == nil {
+		return g.bpm
+	}
+	frac := float64(t.Sub(g.ramp.start)) / float64(g.ramp.duration)
+	switch {
+	case frac <= 0:
+		return g.ramp.from
+	case frac >= 1:
+		return g.ramp.to
+	}
+	return g.ramp.from + (g.ramp.to-g.ramp.from)*frac
+}
+
+// Tempo returns the current tempo in beats per minute.
+func (g *Generator) Tempo() float64 {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return g.bpmAt(time.Now())
+}
+
+// SetTempo changes the tempo immediately: it applies to the pulses that have not been scheduled yet.
+// It stops a running tempo ramp.
+func (g *Generator) SetTempo(bpm float64) error {
+	if bpm <= 0 {
+		return fmt.Errorf("invalid tempo %v", bpm)
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	g.ramp = nil
+	g.bpm = bpm
+	g.reanchor()
+	return nil
+}
+
+// RampTempo changes the tempo linearly from the current tempo to the given one within the given duration.
+// When the generator is not running, the tempo is set immediately.
+func (g *Generator) RampTempo(bpm float64, d time.Duration) error {
+	if bpm <= 0 {
+		return fmt.Errorf("invalid tempo %v", bpm)
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	now := time.Now()
+	if !g.running || d <= 0 {
+		g.ramp = nil
+		g.bpm = bpm
+		g.reanchor()
+		return nil
+	}
+
+	g.ramp = &ramp{from: g.bpmAt(now), to: bpm, start: now, duration: d}
+	return nil
+}
+
+// reanchor lets the pulses after the last computed one follow it with the current tempo.
+// It must be called with the lock held.
+func (g *Generator) reanchor() {
+	if len(g.times) > 0 {
+		g.anchorTick = g.base + uint64(len(g.times)) - 1
+		g.anchorTime = g.times[len(g.times)-1]
+	}
+}
+
+// tick returns the time of the pulse n of the timeline. It must be called with the lock held,
+// while the generator is running and for pulses that have not been trimmed.
+func (g *Generator) tick(n uint64) time.Time {
+	for g.base+uint64(len(g.times)) <= n {
+		k := g.base + uint64(len(g.times))
+		prev := g.times[len(g.times)-1]
+
+		var t time.Time
+		if g.ramp != nil {
+			t = prev.Add(time.Duration(interval(g.bpmAt(prev))))
+			if t.Sub(g.ramp.start) >= g.ramp.duration {
+				g.bpm = g.ramp.to
+				g.ramp = nil
+				g.anchorTime, g.anchorTick = t, k
+			}
+		} else {
+			t = g.anchorTime.Add(time.Duration(float64(k-g.anchorTick) * interval(g.bpm)))
+		}
+		g.times = append(g.times, t)
+	}
+	return g.times[n-g.base]
+}
+
+// passed returns the number of pulses of the timeline up to the given time. It must be called with the lock held.
+func (g *Generator) passed(now time.Time) uint64 {
+	n := g.base
+	for !g.tick(n).After(now) {
+		n++
+	}
+	return n
+}
+
+// trim forgets the times of the pulses that all outs have sent and that have passed.
+// It must be called with the lock held.
+func (g *Generator) trim(now time.Time) {
+	keep := g.base + uint64(len(g.times)) - 1
+	for _, o := range g.outs {
+		if o.next < keep {
+			keep = o.next
+		}
+	}
+
+	i := 0
+	for g.base+uint64(i) < keep && !g.times[i].After(now) {
+		i++
+	}
+	g.times = g.times[i:]
+	g.base += uint64(i)
+}
+
+// Position returns the song position in clock pulses (6 pulses are one song position beat, a 16th note).
+func (g *Generator) Position() uint64 {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if !g.running {
+		return g.position
+	}
+	return g.position + g.passed(time.Now())
+}
+
+// Running reports whether the generator is running.
+func (g *Generator) Running() bool {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return g.running
+}
+
+// SetPosition sets the song position in song position beats (16th notes) and sends it to the outs as song position
+// pointer. The position can only be set while the generator is stopped; Continue continues at the position.
+func (g *Generator) SetPosition(beats int) error {
+	if beats < 0 || beats > 0x3FFF {
+		return fmt.Errorf("invalid song position %v", beats)
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.running {
+		return fmt.Errorf("can't set song position: clock is running")
+	}
+
+	g.position = uint64(beats) * 6
+	g.sendAll([]byte{msgSongPos, byte(beats & 0x7F), byte(beats >> 7)})
+	return nil
+}
+
+// Start sends start and starts the clock at song position 0. It does nothing, if the generator is running.
+func (g *Generator) Start() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.running {
+		return
+	}
+	g.position = 0
+	g.start(msgStart)
+}
+
+// Continue sends continue and continues the clock at the current song position.
+// It does nothing, if the generator is running.
+func (g *Generator) Continue() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.running {
+		return
+	}
+	g.start(msgContinue)
+}
+
+// start starts the timeline. It must be called with the lock held.
+func (g *Generator) start(transport byte) {
+	// the timeline starts late enough for the out with the most negative offset
+	var lead time.Duration
+	for _, o := range g.outs {
+		if -o.offset > lead {
+			lead = -o.offset
+		}
+		o.next = 0
+		o.transport = transport
+	}
+
+	t0 := time.Now().Add(lead)
+	g.times = []time.Time{t0}
+	g.base = 0
+	g.anchorTime, g.anchorTick = t0, 0
+
+	g.running = true
+	g.stop = make(chan struct{})
+	g.done = make(chan struct{})
+	go g.run(g.stop, g.done)
+}
+
+// Stop stops the clock and sends stop. The song position is kept for Continue.
+func (g *Generator) Stop() {
+	g.mx.Lock()
+	if !g.running {
+		g.mx.Unlock()
+		return
+	}
+	done := g.done
+	if g.stop == nil {
+		// another call is stopping the generator
+		g.mx.Unlock()
+		<-done
+		return
+	}
+	close(g.stop)
+	g.stop = nil
+	g.mx.Unlock()
+
+	<-done
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	g.position += g.passed(time.Now())
+	g.running = false
+	g.times = nil
+	if g.ramp != nil {
+		g.bpm = g.ramp.to
+		g.ramp = nil
+	}
+	g.sendAll([]byte{msgStop})
+}
+
+func (g *Generator) run(stop, done chan struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		g.mx.Lock()
+		var (
+			next   *output
+			target time.Time
+		)
+		for _, o := range g.outs {
+			t := g.tick(o.next).Add(o.offset)
+			if next == nil || t.Before(target) {
+				next, target = o, t
+			}
+		}
+		g.mx.Unlock()
+
+		if next == nil {
+			<-stop
+			return
+		}
+
+		if !wait(timer, target, stop) {
+			return
+		}
+
+		g.mx.Lock()
+		select {
+		case <-stop:
+			g.mx.Unlock()
+			return
+		default:
+		}
+		if next.transport != 0 {
+			g.send(next.out, []byte{next.transport})
+			next.transport = 0
+		}
+		g.send(next.out, []byte{msgClock})
+		next.next++
+		g.trim(time.Now())
+		g.mx.Unlock()
+	}
+}
+
+// wait waits until the target time. It returns false, if stop has been closed.
+func wait(timer *time.Timer, target time.Time, stop chan struct{}) bool {
+	d := time.Until(target)
+	if d <= 0 {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer.Reset(d)
+	select {
+	case <-stop:
+		if !timer.Stop() {
+			<-timer.C
+		}
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// sendAll sends the message to all outs. It must be called with the lock held.
+func (g *Generator) sendAll(msg []byte) {
+	for _, o := range g.outs {
+		g.send(o.out, msg)
+	}
+}
+
+// send sends the message. It must be called with the lock held.
+func (g *Generator) send(out Out, msg []byte) {
+	err := out.Send(msg)
+	if err != nil && g.errorHandler != nil {
+		g.errorHandler(fmt.Errorf("can't send % X: %v", msg, err))
+	}
+}
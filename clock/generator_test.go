@@ -0,0 +1,187 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testOut struct {
+	sync.Mutex
+	msgs  []byte
+	times []time.Time
+}
+
+func (o *testOut) Send(data []byte) error {
+	o.Lock()
+	defer o.Unlock()
+	o.msgs = append(o.msgs, data...)
+	o.times = append(o.times, time.Now())
+	return nil
+}
+
+// first returns the time of the first message b.
+func (o *testOut) first(b byte) time.Time {
+	o.Lock()
+	defer o.Unlock()
+	for i, m := range o.msgs {
+		if m == b {
+			return o.times[i]
+		}
+	}
+	return time.Time{}
+}
+
+func (o *testOut) count(b byte) int {
+	o.Lock()
+	defer o.Unlock()
+	n := 0
+	for _, m := range o.msgs {
+		if m == b {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTimeline(t *testing.T) {
+	g, err := NewGenerator(125)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// at 125 BPM a pulse takes 20ms
+	t0 := time.Now()
+	g.times = []time.Time{t0}
+	g.anchorTime = t0
+
+	for _, n := range []uint64{1, 2, 1000, 100000} {
+		if got, want := g.tick(n).Sub(t0), time.Duration(n)*20*time.Millisecond; got != want {
+			t.Errorf("pulse %v at %v, want %v", n, got, want)
+		}
+	}
+
+	g.trim(t0.Add(time.Hour))
+	g.running = true
+	err = g.SetTempo(250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.tick(100010).Sub(t0), 100000*20*time.Millisecond+100*time.Millisecond; got != want {
+		t.Errorf("after tempo change: pulse at %v, want %v", got, want)
+	}
+
+	// ramp from 250 to 125 BPM within 1s: the pulses get longer, until they take 20ms
+	start := g.tick(100010)
+	g.ramp = &ramp{from: 250, to: 125, start: start, duration: time.Second}
+	prev := start
+	var n uint64
+	for n = 100011; g.ramp != nil; n++ {
+		tn := g.tick(n)
+		if d := tn.Sub(prev); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("pulse interval %v during ramp", d)
+		}
+		prev = tn
+	}
+	if d := g.tick(n + 10).Sub(g.tick(n)); d != 200*time.Millisecond {
+		t.Errorf("10 pulses after the ramp take %v, want 200ms", d)
+	}
+	if g.Tempo() != 125 {
+		t.Errorf("tempo %v after ramp", g.Tempo())
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	g, err := NewGenerator(250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out1, out2 testOut
+	g.AddOut(&out1, 0)
+	g.AddOut(&out2, -30*time.Millisecond)
+
+	err = g.SetPosition(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Position() != 96 {
+		t.Errorf("position %v, want 96", g.Position())
+	}
+
+	g.Start()
+	if err := g.AddOut(&testOut{}, 0); err == nil {
+		t.Errorf("expected error when adding an out while running")
+	}
+	if err := g.SetPosition(0); err == nil {
+		t.Errorf("expected error when setting the position while running")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	g.Stop()
+
+	out1.Lock()
+	if len(out1.msgs) < 3 || out1.msgs[0] != msgSongPos || out1.msgs[3] != msgStart || out1.msgs[4] != msgClock {
+		t.Errorf("unexpected messages % X", out1.msgs)
+	}
+	if out1.msgs[len(out1.msgs)-1] != msgStop {
+		t.Errorf("missing stop: % X", out1.msgs)
+	}
+	out1.Unlock()
+
+	// at 250 BPM a pulse takes 10ms
+	n := out1.count(msgClock)
+	if n < 5 || n > 21 {
+		t.Errorf("%v pulses within 200ms", n)
+	}
+
+	if d := out1.first(msgClock).Sub(out2.first(msgClock)); d < 20*time.Millisecond || d > 40*time.Millisecond {
+		t.Errorf("offset %v between the outs, want 30ms", d)
+	}
+
+	pos := g.Position()
+	if pos < uint64(n) || pos > uint64(n)+4 {
+		t.Errorf("position %v after %v pulses", pos, n)
+	}
+
+	g.Continue()
+	time.Sleep(50 * time.Millisecond)
+	g.Stop()
+
+	if out1.count(msgContinue) != 1 || g.Position() <= pos {
+		t.Errorf("continue: position %v", g.Position())
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	g, err := NewGenerator(250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out testOut
+	g.AddOut(&out, 0)
+	g.Start()
+	time.Sleep(20 * time.Millisecond)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			g.Stop()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if g.Running() {
+		t.Errorf("still running")
+	}
+
+	if n := out.count(msgStop); n != 1 {
+		t.Errorf("stop sent %v times", n)
+	}
+}
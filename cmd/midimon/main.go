@@ -0,0 +1,253 @@
+// Command midimon prints the messages that arrive at the MIDI in ports.
+//
+// Usage:
+//
+//	midimon -list
+//	midimon [-in 0,Keyboard] [-ch 1,10] [-type note_on,control_change] [-timing] [-json]
+//
+// Without -in, all in ports are monitored. Ports are given by number or (a part of) their name.
+// The channel filter lets only channel messages of the given channels pass.
+// The type filter takes the names of rtmididrv.StatusType, e.g. note_off, note_on, control_change,
+// program_change, pitch_bend, sysex, system_common or realtime.
+// With -json, every message is printed as one JSON object per line.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/miditext"
+)
+
+var (
+	argList   = flag.Bool("list", false, "list the MIDI in ports and exit")
+	argIn     = flag.String("in", "", "comma separated numbers or names of the in ports to monitor (default: all)")
+	argCh     = flag.String("ch", "", "comma separated channels (1-16) to show")
+	argType   = flag.String("type", "", "comma separated message types to show")
+	argJSON   = flag.Bool("json", false, "print one JSON object per message")
+	argTiming = flag.Bool("timing", false, "show timing messages (clock, MIDI time code) and active sensing")
+)
+
+func main() {
+	flag.Parse()
+
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "midimon: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// filter decides which messages are shown.
+type filter struct {
+	channels map[int]bool
+	types    map[rtmididrv.StatusType]bool
+}
+
+func newFilter(channels, types string) (*filter, error) {
+	f := &filter{}
+
+	if channels != "" {
+		f.channels = map[int]bool{}
+		for _, s := range strings.Split(channels, ",") {
+			ch, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || ch < 1 || ch > 16 {
+				return nil, fmt.Errorf("invalid channel %q", s)
+			}
+			f.channels[ch] = true
+		}
+	}
+
+	if types != "" {
+		f.types = map[rtmididrv.StatusType]bool{}
+		for _, s := range strings.Split(types, ",") {
+			t, err := parseStatusType(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			f.types[t] = true
+		}
+	}
+
+	return f, nil
+}
+
+func parseStatusType(name string) (rtmididrv.StatusType, error) {
+	for t := rtmididrv.StatusNoteOff; t <= rtmididrv.StatusOther; t++ {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown message type %q", name)
+}
+
+func (f *filter) pass(msg []byte) bool {
+	if f.types != nil && !f.types[rtmididrv.StatusTypeOf(msg)] {
+		return false
+	}
+	if f.channels != nil {
+		ch := channel(msg)
+		if ch == 0 || !f.channels[ch] {
+			return false
+		}
+	}
+	return true
+}
+
+// channel returns the channel (1-16) of a channel message, or 0.
+func channel(msg []byte) int {
+	if len(msg) == 0 || msg[0] < 0x80 || msg[0] >= 0xF0 {
+		return 0
+	}
+	return int(msg[0]&0x0F) + 1
+}
+
+// describe returns the text of the message with the name of the controller or SysEx manufacturer.
+func describe(msg []byte) string {
+	text := miditext.Format(msg)
+
+	var name string
+	switch rtmididrv.StatusTypeOf(msg) {
+	case rtmididrv.StatusControlChange:
+		if len(msg) > 1 {
+			name = miditext.ControllerName(msg[1])
+		}
+	case rtmididrv.StatusSysEx:
+		if len(msg) > 1 {
+			name = miditext.ManufacturerName(miditext.ManufacturerID(msg[1:]))
+		}
+	}
+
+	if name == "" {
+		return text
+	}
+	return text + " (" + name + ")"
+}
+
+// event is the JSON form of a message.
+type event struct {
+	Port      string `json:"port"`
+	Number    int    `json:"number"`
+	Timestamp int64  `json:"timestamp"`
+	Delta     int64  `json:"delta"`
+	Type      string `json:"type"`
+	Channel   int    `json:"channel,omitempty"`
+	Data      string `json:"data"`
+	Text      string `json:"text"`
+}
+
+// printer writes the messages of all ports to stdout.
+type printer struct {
+	mx   sync.Mutex
+	json *json.Encoder
+}
+
+func (p *printer) print(in rtmididrv.In, msg []byte, timestampMicroseconds, deltaMicroseconds int64) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.json != nil {
+		p.json.Encode(event{
+			Port:      in.String(),
+			Number:    in.Number(),
+			Timestamp: timestampMicroseconds,
+			Delta:     deltaMicroseconds,
+			Type:      rtmididrv.StatusTypeOf(msg).String(),
+			Channel:   channel(msg),
+			Data:      fmt.Sprintf("% X", msg),
+			Text:      describe(msg),
+		})
+		return
+	}
+
+	fmt.Printf("%12.6f %+10.6f  [%v] %-20s  %s\n",
+		float64(timestampMicroseconds)/1e6, float64(deltaMicroseconds)/1e6, in.Number(), in.String(), describe(msg))
+}
+
+func run() error {
+	drv, err := rtmididrv.New(rtmididrv.IgnoreTypes(false, !*argTiming, !*argTiming))
+	if err != nil {
+		return err
+	}
+
+	// make sure to close all open ports at the end
+	defer drv.Close()
+
+	if *argList {
+		ins, err := drv.Ins()
+		if err != nil {
+			return err
+		}
+		for _, in := range ins {
+			fmt.Printf("[%v] %s\n", in.Number(), in.String())
+		}
+		return nil
+	}
+
+	f, err := newFilter(*argCh, *argType)
+	if err != nil {
+		return err
+	}
+
+	var ins []rtmididrv.In
+	if *argIn == "" {
+		all, err := drv.Ins()
+		if err != nil {
+			return err
+		}
+		for _, in := range all {
+			ins = append(ins, in.(rtmididrv.In))
+		}
+	} else {
+		for _, spec := range strings.Split(*argIn, ",") {
+			in, err := drv.FindIn(strings.TrimSpace(spec))
+			if err != nil {
+				return err
+			}
+			ins = append(ins, in)
+		}
+	}
+
+	if len(ins) == 0 {
+		return fmt.Errorf("no MIDI in ports")
+	}
+
+	p := &printer{}
+	if *argJSON {
+		p.json = json.NewEncoder(os.Stdout)
+	}
+
+	for _, in := range ins {
+		in := in
+		err := in.Open()
+		if err != nil {
+			return err
+		}
+
+		err = in.SetTimestampedListener(func(msg []byte, timestampMicroseconds, deltaMicroseconds int64) {
+			if f.pass(msg) {
+				p.print(in, msg, timestampMicroseconds, deltaMicroseconds)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if !*argJSON {
+			fmt.Fprintf(os.Stderr, "monitoring [%v] %s\n", in.Number(), in.String())
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+
+	return nil
+}
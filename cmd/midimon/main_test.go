@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		want string
+	}{
+		{[]byte{0x91, 61, 100}, "noteon ch=2 key=C#4 vel=100"},
+		{[]byte{0xB0, 39, 5}, "cc ch=1 num=39 val=5 (Volume LSB)"},
+		{[]byte{0xB0, 3, 5}, "cc ch=1 num=3 val=5"},
+		{[]byte{0xF0, 0x00, 0x20, 0x29, 0x01, 0xF7}, "sysex 00 20 29 01 (Focusrite/Novation)"},
+		{[]byte{0xF0, 0x41, 0x10, 0xF7}, "sysex 41 10 (Roland)"},
+		{[]byte{0x90, 0x3C}, "raw 90 3C"},
+	}
+
+	for _, test := range tests {
+		if got := describe(test.msg); got != test.want {
+			t.Errorf("describe(% X) = %q, want %q", test.msg, got, test.want)
+		}
+	}
+}
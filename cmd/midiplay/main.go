@@ -0,0 +1,128 @@
+// Command midiplay plays a Standard MIDI File to MIDI out ports.
+//
+// Usage:
+//
+//	midiplay -out PORT[,PORT...] [-bar 1] [-tempo 1] [-loop] [-mute 2,3] FILE.mid
+//
+// The ports are given by number or (a part of) their name: the first one gets the tracks of port 0 of the file,
+// the second one those of port 1 and so on. -tempo scales the tempo of the file, -mute mutes tracks by
+// their index, counted from 0. On SIGINT or SIGTERM the playback stops and the held notes are released.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/player"
+	"github.com/minikomi/rtmididrv/smf"
+)
+
+var (
+	argOut   = flag.String("out", "", "comma separated numbers or names of the out ports")
+	argBar   = flag.Int("bar", 1, "bar to start at")
+	argTempo = flag.Float64("tempo", 1, "tempo scale, e.g. 0.5 for half the tempo")
+	argLoop  = flag.Bool("loop", false, "play the file again and again")
+	argMute  = flag.String("mute", "", "comma separated indices of tracks to mute")
+)
+
+func main() {
+	flag.Parse()
+
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "midiplay: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if flag.NArg() != 1 {
+		return fmt.Errorf("missing file")
+	}
+
+	if *argOut == "" {
+		return fmt.Errorf("missing -out")
+	}
+
+	f, err := smf.ReadFile(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	drv, err := rtmididrv.New()
+	if err != nil {
+		return err
+	}
+
+	// make sure to close all open ports at the end
+	defer drv.Close()
+
+	var outs []player.Out
+	for _, spec := range strings.Split(*argOut, ",") {
+		out, err := drv.FindOut(strings.TrimSpace(spec))
+		if err != nil {
+			return err
+		}
+		err = out.Open()
+		if err != nil {
+			return err
+		}
+		outs = append(outs, out)
+	}
+
+	p, err := player.New(f, outs...)
+	if err != nil {
+		return err
+	}
+
+	p.SetErrorHandler(func(err error) {
+		fmt.Fprintf(os.Stderr, "midiplay: %v\n", err)
+	})
+	p.SetLoop(*argLoop)
+
+	err = p.SetTempoScale(*argTempo)
+	if err != nil {
+		return err
+	}
+
+	if *argMute != "" {
+		for _, s := range strings.Split(*argMute, ",") {
+			track, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || track < 0 || track >= len(f.Tracks) {
+				return fmt.Errorf("invalid track %q", s)
+			}
+			p.Mute(track, true)
+		}
+	}
+
+	err = p.SeekBar(*argBar)
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	p.Start()
+
+	finished := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-sig:
+		p.Stop()
+	case <-finished:
+	}
+
+	return nil
+}
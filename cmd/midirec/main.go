@@ -0,0 +1,103 @@
+// Command midirec records MIDI in ports into a Standard MIDI File until it is interrupted.
+//
+// Usage:
+//
+//	midirec [-in 0,Keyboard] [-ppq 960] [-bpm 120] FILE.mid
+//
+// Without -in, all in ports are recorded. A single port is written as format 0, more ports as
+// format 1 with one track per port. The file is written when the program gets SIGINT or SIGTERM.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/recorder"
+)
+
+var (
+	argIn  = flag.String("in", "", "comma separated numbers or names of the in ports to record (default: all)")
+	argPPQ = flag.Int("ppq", 960, "resolution in ticks per quarter note")
+	argBPM = flag.Float64("bpm", 120, "tempo in beats per minute")
+)
+
+func main() {
+	flag.Parse()
+
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "midirec: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if flag.NArg() != 1 {
+		return fmt.Errorf("missing file")
+	}
+	file := flag.Arg(0)
+
+	drv, err := rtmididrv.New(rtmididrv.IgnoreTypes(false, true, true))
+	if err != nil {
+		return err
+	}
+
+	// make sure to close all open ports at the end
+	defer drv.Close()
+
+	var ins []rtmididrv.In
+	if *argIn == "" {
+		all, err := drv.Ins()
+		if err != nil {
+			return err
+		}
+		for _, in := range all {
+			ins = append(ins, in.(rtmididrv.In))
+		}
+	} else {
+		for _, spec := range strings.Split(*argIn, ",") {
+			in, err := drv.FindIn(strings.TrimSpace(spec))
+			if err != nil {
+				return err
+			}
+			ins = append(ins, in)
+		}
+	}
+
+	if len(ins) == 0 {
+		return fmt.Errorf("no MIDI in ports")
+	}
+
+	// catch the signals before recording, so that nothing gets lost
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	rec, err := recorder.New(drv, recorder.PPQ(*argPPQ), recorder.BPM(*argBPM))
+	if err != nil {
+		return err
+	}
+	for _, in := range ins {
+		err := rec.Record(in)
+		if err != nil {
+			rec.Stop()
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "recording [%v] %s\n", in.Number(), in.String())
+	}
+
+	<-sig
+	signal.Stop(sig)
+
+	err = rec.WriteFile(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "written %s\n", file)
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/miditext"
+)
+
+// config is the content of the configuration file, e.g.
+//
+//	{
+//	  "routes": [
+//	    {
+//	      "name": "keyboard to synth",
+//	      "from": ["Keystation*"],
+//	      "to": ["Synth*", "3"],
+//	      "channels": [1],
+//	      "types": ["note_on", "note_off", "control_change"],
+//	      "notes": "C2-C6",
+//	      "transform": {"channel": 2, "transpose": -12, "velocity": 0.8}
+//	    }
+//	  ]
+//	}
+//
+// from and to are patterns for the names of the ports (see path.Match) or port numbers.
+// All filters are optional: channels (1-16), types (see rtmididrv.StatusType) and notes, a range of keys
+// that applies to note and polyphonic aftertouch messages. The transform is optional, too: it sets the
+// channel of channel messages, transposes notes (dropping those out of range) and scales the velocity of note ons.
+type config struct {
+	Routes []routeConfig `json:"routes"`
+}
+
+type routeConfig struct {
+	Name      string          `json:"name"`
+	From      []string        `json:"from"`
+	To        []string        `json:"to"`
+	Channels  []int           `json:"channels"`
+	Types     []string        `json:"types"`
+	Notes     string          `json:"notes"`
+	Transform transformConfig `json:"transform"`
+}
+
+type transformConfig struct {
+	Channel   int     `json:"channel"`
+	Transpose int     `json:"transpose"`
+	Velocity  float64 `json:"velocity"`
+}
+
+// loadConfig reads the configuration file and returns its routes.
+func loadConfig(file string) ([]*route, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data)
+}
+
+func parseConfig(data []byte) ([]*route, error) {
+	var c config
+	err := json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse config: %v", err)
+	}
+
+	routes := make([]*route, len(c.Routes))
+	for i, rc := range c.Routes {
+		routes[i], err = newRoute(rc)
+		if err != nil {
+			name := rc.Name
+			if name == "" {
+				name = strconv.Itoa(i + 1)
+			}
+			return nil, fmt.Errorf("route %s: %v", name, err)
+		}
+	}
+	return routes, nil
+}
+
+// route forwards the messages of the matching in ports to the matching out ports.
+type route struct {
+	name     string
+	from, to []string
+
+	// channels and types are nil, if all pass
+	channels       map[int]bool
+	types          map[rtmididrv.StatusType]bool
+	minKey, maxKey int
+
+	channel   int
+	transpose int
+	velocity  float64
+}
+
+func newRoute(rc routeConfig) (*route, error) {
+	r := &route{
+		name:      rc.Name,
+		from:      rc.From,
+		to:        rc.To,
+		maxKey:    127,
+		channel:   rc.Transform.Channel,
+		transpose: rc.Transform.Transpose,
+		velocity:  rc.Transform.Velocity,
+	}
+
+	if len(r.from) == 0 || len(r.to) == 0 {
+		return nil, fmt.Errorf("from and to are required")
+	}
+
+	for _, p := range append(append([]string(nil), r.from...), r.to...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+
+	if len(rc.Channels) > 0 {
+		r.channels = map[int]bool{}
+		for _, ch := range rc.Channels {
+			if ch < 1 || ch > 16 {
+				return nil, fmt.Errorf("invalid channel %v", ch)
+			}
+			r.channels[ch] = true
+		}
+	}
+
+	if len(rc.Types) > 0 {
+		r.types = map[rtmididrv.StatusType]bool{}
+		for _, name := range rc.Types {
+			t, err := parseStatusType(name)
+			if err != nil {
+				return nil, err
+			}
+			r.types[t] = true
+		}
+	}
+
+	if rc.Notes != "" {
+		var err error
+		r.minKey, r.maxKey, err = parseNoteRange(rc.Notes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.channel < 0 || r.channel > 16 {
+		return nil, fmt.Errorf("invalid transform channel %v", r.channel)
+	}
+
+	if r.velocity < 0 {
+		return nil, fmt.Errorf("invalid velocity factor %v", r.velocity)
+	}
+
+	return r, nil
+}
+
+func parseStatusType(name string) (rtmididrv.StatusType, error) {
+	for t := rtmididrv.StatusNoteOff; t <= rtmididrv.StatusOther; t++ {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown message type %q", name)
+}
+
+// parseNoteRange parses a range of keys like C2-C6, C-1-B0 or 36-84.
+func parseNoteRange(s string) (min, max int, err error) {
+	for idx := 1; idx < len(s); idx++ {
+		if s[idx] != '-' {
+			continue
+		}
+		min, err = parseKey(s[:idx])
+		if err != nil {
+			continue
+		}
+		max, err = parseKey(s[idx+1:])
+		if err == nil && min <= max {
+			return min, max, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid note range %q", s)
+}
+
+func parseKey(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if k, err := miditext.ParseNoteName(s); err == nil {
+		return int(k), nil
+	}
+	k, err := strconv.Atoi(s)
+	if err != nil || k < 0 || k > 127 {
+		return 0, fmt.Errorf("invalid key %q", s)
+	}
+	return k, nil
+}
+
+// matchPort reports whether one of the patterns matches the port.
+func matchPort(patterns []string, number int, name string) bool {
+	for _, p := range patterns {
+		if n, err := strconv.Atoi(p); err == nil {
+			if n == number {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isNote reports whether msg is a note on, note off or polyphonic aftertouch message.
+func isNote(msg []byte) bool {
+	if len(msg) != 3 {
+		return false
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90, 0xA0:
+		return true
+	}
+	return false
+}
+
+// apply returns the message as it is sent by the route, or nil if the route does not pass it.
+func (r *route) apply(msg []byte) []byte {
+	if len(msg) == 0 {
+		return nil
+	}
+
+	if r.types != nil && !r.types[rtmididrv.StatusTypeOf(msg)] {
+		return nil
+	}
+
+	channelMsg := msg[0] >= 0x80 && msg[0] < 0xF0
+	if r.channels != nil && (!channelMsg || !r.channels[int(msg[0]&0x0F)+1]) {
+		return nil
+	}
+
+	if isNote(msg) && (int(msg[1]) < r.minKey || int(msg[1]) > r.maxKey) {
+		return nil
+	}
+
+	if !channelMsg || r.channel == 0 && r.transpose == 0 && r.velocity == 0 {
+		return msg
+	}
+
+	out := append([]byte(nil), msg...)
+
+	if r.channel > 0 {
+		out[0] = out[0]&0xF0 | byte(r.channel-1)
+	}
+
+	if isNote(out) && r.transpose != 0 {
+		key := int(out[1]) + r.transpose
+		if key < 0 || key > 127 {
+			return nil
+		}
+		out[1] = byte(key)
+	}
+
+	if out[0]&0xF0 == 0x90 && len(out) == 3 && out[2] > 0 && r.velocity > 0 {
+		vel := int(float64(out[2])*r.velocity + 0.5)
+		switch {
+		case vel < 1:
+			vel = 1
+		case vel > 127:
+			vel = 127
+		}
+		out[2] = byte(vel)
+	}
+
+	return out
+}
@@ -0,0 +1,273 @@
+// Command midiroute forwards MIDI messages from in ports to out ports, as configured in a JSON file.
+//
+// Usage:
+//
+//	midiroute -config routes.json [-rescan 2s] [-log]
+//
+// See the type config for the format of the file. The configuration is reloaded on SIGHUP;
+// notes that are held at that time are released on the ports where they were started.
+// The ports are rescanned periodically, so that routes are reconnected when devices reappear.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/minikomi/rtmididrv"
+)
+
+var (
+	argConfig = flag.String("config", "", "configuration file")
+	argRescan = flag.Duration("rescan", 2*time.Second, "interval for rescanning the ports (0 disables rescanning)")
+	argLog    = flag.Bool("log", false, "log the opening and closing of ports and the warnings of the backend")
+)
+
+func main() {
+	flag.Parse()
+
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "midiroute: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// daemon keeps the ports of the routes open.
+type daemon struct {
+	file string
+	drv  *rtmididrv.Driver
+
+	// mx guards the router and the generation. The listeners hold it while they route a message,
+	// so it is never held during native calls.
+	mx     sync.Mutex
+	router *router
+	// generation is increased whenever the routes are replaced
+	generation int
+}
+
+// reload reads the configuration file again. If it is invalid, the routes are kept.
+func (d *daemon) reload() error {
+	routes, err := loadConfig(d.file)
+	if err != nil {
+		return err
+	}
+
+	d.mx.Lock()
+	d.router.routes = routes
+	d.generation++
+	d.mx.Unlock()
+
+	log.Printf("loaded %v routes from %s", len(routes), d.file)
+	return d.rescan()
+}
+
+// rescan opens the ports that are needed by the routes and closes the ones that are gone or not needed anymore.
+// Out ports with held notes are kept open until the notes are released. If such a port is gone,
+// the note offs are sent before it is closed.
+// The ports are opened and closed without holding the lock, so that the routing goes on meanwhile.
+func (d *daemon) rescan() error {
+	ins, err := d.drv.Ins()
+	if err != nil {
+		return err
+	}
+
+	outs, err := d.drv.Outs()
+	if err != nil {
+		return err
+	}
+
+	var closing []rtmididrv.Port
+	var openOuts []rtmididrv.Out
+	var openIns []rtmididrv.In
+
+	d.mx.Lock()
+	r := d.router
+	generation := d.generation
+
+	available := map[port]bool{}
+	for _, o := range outs {
+		p := port{o.Number(), o.String()}
+		available[p] = true
+		if _, has := r.outs[p]; !has && r.wantsOut(o) {
+			openOuts = append(openOuts, o.(rtmididrv.Out))
+		}
+	}
+
+	for p, s := range r.outs {
+		o := s.(rtmididrv.Out)
+		if !available[p] || !r.wantsOut(o) && !r.holds(p) {
+			// the port may only be gone from the list, so try to end its notes
+			r.releaseOut(p)
+			delete(r.outs, p)
+			closing = append(closing, o)
+		}
+	}
+
+	available = map[port]bool{}
+	for _, i := range ins {
+		p := port{i.Number(), i.String()}
+		available[p] = true
+		if _, has := r.ins[p]; !has && r.wantsIn(i) {
+			openIns = append(openIns, i.(rtmididrv.In))
+		}
+	}
+
+	for p, in := range r.ins {
+		if !available[p] || !r.wantsIn(in) {
+			delete(r.ins, p)
+			// a device that is gone won't send the note offs
+			r.releaseIn(p)
+			closing = append(closing, in)
+		}
+	}
+	d.mx.Unlock()
+
+	// closing waits for the native input threads, which must not wait for the lock
+	d.close(closing)
+
+	for i, o := range openOuts {
+		if err := o.Open(); err != nil {
+			log.Printf("can't open out [%v] %s: %v", o.Number(), o, err)
+			openOuts[i] = nil
+		}
+	}
+
+	for i, in := range openIns {
+		if err := d.listen(port{in.Number(), in.String()}, in); err != nil {
+			log.Printf("can't listen to in [%v] %s: %v", in.Number(), in, err)
+			openIns[i] = nil
+		}
+	}
+
+	closing = nil
+
+	d.mx.Lock()
+	// the routes may have been replaced in the meantime
+	changed := d.generation != generation
+	for _, o := range openOuts {
+		if o == nil {
+			continue
+		}
+		if changed && !r.wantsOut(o) {
+			closing = append(closing, o)
+			continue
+		}
+		log.Printf("opened out [%v] %s", o.Number(), o)
+		r.outs[port{o.Number(), o.String()}] = o
+	}
+	for _, in := range openIns {
+		if in == nil {
+			continue
+		}
+		if changed && !r.wantsIn(in) {
+			// it may have routed notes already
+			r.releaseIn(port{in.Number(), in.String()})
+			closing = append(closing, in)
+			continue
+		}
+		log.Printf("opened in [%v] %s", in.Number(), in)
+		r.ins[port{in.Number(), in.String()}] = in
+	}
+	d.mx.Unlock()
+
+	d.close(closing)
+
+	return nil
+}
+
+// close closes the ports. It must be called without holding the lock.
+func (d *daemon) close(ports []rtmididrv.Port) {
+	for _, p := range ports {
+		if err := p.Close(); err != nil {
+			log.Printf("can't close %s: %v", p, err)
+		} else {
+			log.Printf("closed [%v] %s", p.Number(), p)
+		}
+	}
+}
+
+func (d *daemon) listen(p port, in rtmididrv.In) error {
+	err := in.Open()
+	if err != nil {
+		return err
+	}
+
+	err = in.SetTimestampedListener(func(msg []byte, _, _ int64) {
+		d.mx.Lock()
+		d.router.handle(p, msg)
+		d.mx.Unlock()
+	})
+	if err != nil {
+		in.Close()
+	}
+	return err
+}
+
+func run() error {
+	if *argConfig == "" {
+		return fmt.Errorf("missing -config")
+	}
+
+	routes, err := loadConfig(*argConfig)
+	if err != nil {
+		return err
+	}
+
+	// SysEx and timing messages are routed, too
+	options := []rtmididrv.Option{rtmididrv.IgnoreTypes(false, false, true)}
+	if *argLog {
+		options = append(options, rtmididrv.Logger(rtmididrv.NewTextLogHandler(os.Stderr, rtmididrv.LogInfo)))
+	}
+
+	drv, err := rtmididrv.New(options...)
+	if err != nil {
+		return err
+	}
+
+	// make sure to close all open ports at the end
+	defer drv.Close()
+
+	d := &daemon{file: *argConfig, drv: drv, router: newRouter(routes)}
+
+	err = d.rescan()
+	if err != nil {
+		return err
+	}
+
+	var tick <-chan time.Time
+	if *argRescan > 0 {
+		t := time.NewTicker(*argRescan)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-tick:
+			if err := d.rescan(); err != nil {
+				log.Printf("can't rescan ports: %v", err)
+			}
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				if err := d.reload(); err != nil {
+					log.Printf("can't reload %s: %v", d.file, err)
+				}
+				continue
+			}
+
+			d.mx.Lock()
+			d.router.release()
+			d.mx.Unlock()
+			return nil
+		}
+	}
+}
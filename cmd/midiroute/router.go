@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gomidi/connect"
+	"github.com/minikomi/rtmididrv"
+)
+
+// port identifies a MIDI port. When a device reappears, it may get another number.
+type port struct {
+	number int
+	name   string
+}
+
+// sender is the part of an out port that the router uses.
+type sender interface {
+	Send([]byte) error
+}
+
+// heldKey identifies a note that is held at an in port.
+type heldKey struct {
+	in      port
+	channel byte
+	key     byte
+}
+
+// heldNote is a note on that has been sent for a held note. The note off is sent to the same port,
+// channel and key, even if the routes have changed in the meantime.
+type heldNote struct {
+	out     port
+	channel byte
+	key     byte
+}
+
+// router forwards the messages of the in ports to the out ports according to the routes.
+type router struct {
+	routes []*route
+	ins    map[port]rtmididrv.In
+	outs   map[port]sender
+	held   map[heldKey][]heldNote
+}
+
+func newRouter(routes []*route) *router {
+	return &router{
+		routes: routes,
+		ins:    map[port]rtmididrv.In{},
+		outs:   map[port]sender{},
+		held:   map[heldKey][]heldNote{},
+	}
+}
+
+// handle routes a message that arrived at the in port. It must be called with the lock of the daemon held.
+func (r *router) handle(in port, msg []byte) {
+	if len(msg) == 3 && (msg[0]&0xF0 == 0x80 || msg[0]&0xF0 == 0x90 && msg[2] == 0) {
+		k := heldKey{in: in, channel: msg[0] & 0x0F, key: msg[1]}
+		if notes, has := r.held[k]; has {
+			delete(r.held, k)
+			for _, n := range notes {
+				r.send(n.out, []byte{0x80 | n.channel, n.key, msg[2]})
+			}
+			return
+		}
+	}
+
+	for _, rt := range r.routes {
+		if !matchPort(rt.from, in.number, in.name) {
+			continue
+		}
+
+		out := rt.apply(msg)
+		if out == nil {
+			continue
+		}
+
+		for p := range r.outs {
+			if !matchPort(rt.to, p.number, p.name) {
+				continue
+			}
+
+			if r.send(p, out) && len(out) == 3 && out[0]&0xF0 == 0x90 && out[2] > 0 {
+				k := heldKey{in: in, channel: msg[0] & 0x0F, key: msg[1]}
+				r.held[k] = append(r.held[k], heldNote{out: p, channel: out[0] & 0x0F, key: out[1]})
+			}
+		}
+	}
+}
+
+// send sends msg to the out port, if it is open, and reports whether it succeeded.
+func (r *router) send(p port, msg []byte) bool {
+	out, has := r.outs[p]
+	if !has {
+		return false
+	}
+	err := out.Send(msg)
+	if err != nil {
+		log.Printf("can't send to [%v] %s: %v", p.number, p.name, err)
+		return false
+	}
+	return true
+}
+
+// release sends note offs for all held notes.
+func (r *router) release() {
+	for k, notes := range r.held {
+		delete(r.held, k)
+		for _, n := range notes {
+			r.send(n.out, []byte{0x80 | n.channel, n.key, 0})
+		}
+	}
+}
+
+// wantsIn reports whether a route takes messages from the port.
+func (r *router) wantsIn(p connect.Port) bool {
+	for _, rt := range r.routes {
+		if matchPort(rt.from, p.Number(), p.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsOut reports whether a route sends messages to the port.
+func (r *router) wantsOut(p connect.Port) bool {
+	for _, rt := range r.routes {
+		if matchPort(rt.to, p.Number(), p.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseIn sends note offs for the notes that are held at the in port.
+func (r *router) releaseIn(in port) {
+	for k, notes := range r.held {
+		if k.in != in {
+			continue
+		}
+		delete(r.held, k)
+		for _, n := range notes {
+			r.send(n.out, []byte{0x80 | n.channel, n.key, 0})
+		}
+	}
+}
+
+// releaseOut sends note offs for the notes that are held at the out port.
+func (r *router) releaseOut(out port) {
+	for k, notes := range r.held {
+		var kept []heldNote
+		for _, n := range notes {
+			if n.out == out {
+				r.send(n.out, []byte{0x80 | n.channel, n.key, 0})
+			} else {
+				kept = append(kept, n)
+			}
+		}
+		if len(kept) == 0 {
+			delete(r.held, k)
+		} else {
+			r.held[k] = kept
+		}
+	}
+}
+
+// holds reports whether notes are held at the out port.
+func (r *router) holds(out port) bool {
+	for _, notes := range r.held {
+		for _, n := range notes {
+			if n.out == out {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+type recorder struct {
+	sent [][]byte
+}
+
+func (r *recorder) Send(msg []byte) error {
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+func (r *recorder) expect(t *testing.T, want ...[]byte) {
+	t.Helper()
+	if fmt.Sprintf("% X", r.sent) != fmt.Sprintf("% X", want) {
+		t.Errorf("sent % X, want % X", r.sent, want)
+	}
+	r.sent = nil
+}
+
+func mustParse(t *testing.T, cfg string) []*route {
+	routes, err := parseConfig([]byte(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+func TestRouteApply(t *testing.T) {
+	routes := mustParse(t, `{"routes": [{
+		"from": ["*"], "to": ["*"],
+		"channels": [1], "types": ["note_on", "note_off", "control_change"], "notes": "C2-C6",
+		"transform": {"channel": 2, "transpose": -12, "velocity": 0.5}
+	}]}`)
+	r := routes[0]
+
+	tests := []struct {
+		msg, want []byte
+	}{
+		{[]byte{0x90, 60, 100}, []byte{0x91, 48, 50}},
+		{[]byte{0x90, 60, 1}, []byte{0x91, 48, 1}},
+		{[]byte{0x80, 60, 64}, []byte{0x81, 48, 64}},
+		{[]byte{0xB0, 7, 100}, []byte{0xB1, 7, 100}},
+		{[]byte{0x91, 60, 100}, nil},
+		{[]byte{0x90, 20, 100}, nil},
+		{[]byte{0xC0, 1}, nil},
+		{[]byte{0xF8}, nil},
+	}
+
+	for _, test := range tests {
+		if got := r.apply(test.msg); !bytes.Equal(got, test.want) {
+			t.Errorf("apply(% X) = % X, want % X", test.msg, got, test.want)
+		}
+	}
+}
+
+func TestHeldNotesSurviveReload(t *testing.T) {
+	keys, synthA, synthB := port{0, "Keys"}, port{1, "Synth A"}, port{2, "Synth B"}
+	a, b := &recorder{}, &recorder{}
+
+	r := newRouter(mustParse(t, `{"routes": [{"from": ["Keys"], "to": ["Synth A"], "transform": {"transpose": 12}}]}`))
+	r.outs[synthA] = a
+	r.outs[synthB] = b
+
+	r.handle(keys, []byte{0x90, 60, 100})
+	a.expect(t, []byte{0x90, 72, 100})
+
+	// the new routes send elsewhere, but the note off goes where the note on went
+	r.routes = mustParse(t, `{"routes": [{"from": ["Keys"], "to": ["Synth B"]}]}`)
+	r.handle(keys, []byte{0x90, 60, 0})
+	a.expect(t, []byte{0x80, 72, 0})
+	b.expect(t)
+
+	r.handle(keys, []byte{0x90, 62, 100})
+	r.handle(keys, []byte{0x80, 62, 0})
+	b.expect(t, []byte{0x90, 62, 100}, []byte{0x80, 62, 0})
+
+	r.handle(keys, []byte{0x90, 64, 100})
+	b.expect(t, []byte{0x90, 64, 100})
+	if !r.holds(synthB) {
+		t.Errorf("expected a held note at Synth B")
+	}
+	r.releaseIn(keys)
+	b.expect(t, []byte{0x80, 64, 0})
+}
+
+func TestReleaseOut(t *testing.T) {
+	keys, synthA, synthB := port{0, "Keys"}, port{1, "Synth A"}, port{2, "Synth B"}
+	a, b := &recorder{}, &recorder{}
+
+	r := newRouter(mustParse(t, `{"routes": [{"from": ["Keys"], "to": ["Synth *"]}]}`))
+	r.outs[synthA] = a
+	r.outs[synthB] = b
+
+	r.handle(keys, []byte{0x90, 60, 100})
+	a.expect(t, []byte{0x90, 60, 100})
+	b.expect(t, []byte{0x90, 60, 100})
+
+	r.releaseOut(synthA)
+	a.expect(t, []byte{0x80, 60, 0})
+	b.expect(t)
+	if r.holds(synthA) || !r.holds(synthB) {
+		t.Errorf("expected the note to be held at Synth B only")
+	}
+
+	// the note off only goes to the remaining port
+	r.handle(keys, []byte{0x80, 60, 0})
+	a.expect(t)
+	b.expect(t, []byte{0x80, 60, 0})
+}
+
+func TestParseNoteRange(t *testing.T) {
+	for s, want := range map[string][2]int{"C2-C6": {36, 84}, "C-1-B0": {0, 23}, "36 - 84": {36, 84}} {
+		min, max, err := parseNoteRange(s)
+		if err != nil || min != want[0] || max != want[1] {
+			t.Errorf("parseNoteRange(%q) = %v, %v, %v, want %v", s, min, max, err, want)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, cfg := range []string{
+		`{"routes": [{"from": ["*"]}]}`,
+		`{"routes": [{"from": ["*"], "to": ["*"], "channels": [17]}]}`,
+		`{"routes": [{"from": ["*"], "to": ["*"], "types": ["noteon"]}]}`,
+		`{"routes": [{"from": ["*"], "to": ["*"], "notes": "C6-C2"}]}`,
+		`{"routes": [{"from": ["["], "to": ["*"]}]}`,
+	} {
+		if _, err := parseConfig([]byte(cfg)); err == nil {
+			t.Errorf("expected error for %s", cfg)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Command midisend sends MIDI messages to a MIDI out port.
+//
+// Usage:
+//
+//	midisend -list
+//	midisend -out PORT [-delay 10ms] [-repeat 1] MESSAGE...
+//
+// The port is given by number or (a part of) its name. Every MESSAGE is one argument, so messages
+// with spaces have to be quoted. A MESSAGE is one of
+//
+//	"90 3C 64"                      hex bytes, starting with a status byte (or "raw 90 3C 64")
+//	"noteon ch=1 key=C4 vel=100"    a message in the text notation of package miditext
+//	setup.syx                       a file with SysEx messages (ending in .syx)
+//	"wait 500ms"                    a pause
+//
+// The messages are sent in the given order with the delay between two messages; a pause replaces
+// the delay. With -repeat, all of them are sent again. For example:
+//
+//	midisend -out Synth "C0 05" "wait 100ms" "noteon ch=1 key=C4 vel=100"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/miditext"
+)
+
+var (
+	argList   = flag.Bool("list", false, "list the MIDI out ports and exit")
+	argOut    = flag.String("out", "", "number or name of the out port")
+	argDelay  = flag.Duration("delay", 0, "delay between the messages")
+	argRepeat = flag.Int("repeat", 1, "number of times the messages are sent")
+)
+
+func main() {
+	flag.Parse()
+
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "midisend: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// step is a message to send or a pause.
+type step struct {
+	msg  []byte
+	wait time.Duration
+}
+
+// parseSteps returns the steps for the arguments.
+func parseSteps(args []string) ([]step, error) {
+	var steps []step
+
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		switch {
+		case strings.HasPrefix(arg, "wait "):
+			d, err := time.ParseDuration(strings.TrimSpace(arg[len("wait "):]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pause %q: %v", arg, err)
+			}
+			steps = append(steps, step{wait: d})
+		case strings.HasSuffix(strings.ToLower(arg), ".syx"):
+			msgs, err := readSysEx(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, msg := range msgs {
+				steps = append(steps, step{msg: msg})
+			}
+		default:
+			text := arg
+			if isHex(arg) {
+				text = "raw " + arg
+			}
+			msg, err := miditext.Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			if len(msg) == 0 {
+				return nil, fmt.Errorf("empty message %q", arg)
+			}
+			steps = append(steps, step{msg: msg})
+		}
+	}
+
+	return steps, nil
+}
+
+// isHex reports whether arg is given as hex bytes that start with a status byte, as in "90 3C 64".
+func isHex(arg string) bool {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 || len(fields[0]) != 2 {
+		return false
+	}
+	if status, err := strconv.ParseUint(fields[0], 16, 8); err != nil || status < 0x80 {
+		return false
+	}
+	for _, f := range fields[1:] {
+		if len(f)%2 != 0 || strings.Trim(f, "0123456789abcdefABCDEF") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readSysEx returns the SysEx messages of a .syx file.
+func readSysEx(file string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs [][]byte
+	for offset := 0; len(data) > 0; {
+		if data[0] != 0xF0 {
+			return nil, fmt.Errorf("%s: expected F0 at offset %v, got %02X", file, offset, data[0])
+		}
+		end := 1
+		for end < len(data) && data[end] != 0xF7 {
+			end++
+		}
+		if end == len(data) {
+			return nil, fmt.Errorf("%s: SysEx message without F7", file)
+		}
+		msgs = append(msgs, data[:end+1])
+		data = data[end+1:]
+		offset += end + 1
+	}
+	return msgs, nil
+}
+
+func run() error {
+	drv, err := rtmididrv.New()
+	if err != nil {
+		return err
+	}
+
+	// make sure to close all open ports at the end
+	defer drv.Close()
+
+	if *argList {
+		outs, err := drv.Outs()
+		if err != nil {
+			return err
+		}
+		for _, out := range outs {
+			fmt.Printf("[%v] %s\n", out.Number(), out.String())
+		}
+		return nil
+	}
+
+	if *argOut == "" {
+		return fmt.Errorf("missing -out")
+	}
+
+	steps, err := parseSteps(flag.Args())
+	if err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		return fmt.Errorf("no messages")
+	}
+
+	out, err := drv.FindOut(*argOut)
+	if err != nil {
+		return err
+	}
+
+	err = out.Open()
+	if err != nil {
+		return err
+	}
+
+	return play(out.Send, time.Sleep, steps, *argRepeat, *argDelay)
+}
+
+// play sends the messages of the steps repeat times. Two messages in a row are separated by the delay.
+func play(send func([]byte) error, sleep func(time.Duration), steps []step, repeat int, delay time.Duration) error {
+	afterMsg := false
+	for i := 0; i < repeat; i++ {
+		for _, s := range steps {
+			if s.msg == nil {
+				sleep(s.wait)
+				afterMsg = false
+				continue
+			}
+			if afterMsg && delay > 0 {
+				sleep(delay)
+			}
+			err := send(s.msg)
+			if err != nil {
+				return err
+			}
+			afterMsg = true
+		}
+	}
+	return nil
+}
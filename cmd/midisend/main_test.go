@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSteps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "midisend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	syx := filepath.Join(dir, "setup.syx")
+	err = ioutil.WriteFile(syx, []byte{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7, 0xF0, 0x41, 0xF7}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := parseSteps([]string{
+		"noteon ch=1 key=C4 vel=100", " wait 50ms ", "90 3C 64", "raw 90 3C 64", "cc ch=1 num=7 val=100", "b0 07 64", syx,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []step{
+		{msg: []byte{0x90, 0x3C, 0x64}},
+		{wait: 50 * time.Millisecond},
+		{msg: []byte{0x90, 0x3C, 0x64}},
+		{msg: []byte{0x90, 0x3C, 0x64}},
+		{msg: []byte{0xB0, 0x07, 0x64}},
+		{msg: []byte{0xB0, 0x07, 0x64}},
+		{msg: []byte{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}},
+		{msg: []byte{0xF0, 0x41, 0xF7}},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %v steps, want %v", len(steps), len(want))
+	}
+	for i := range steps {
+		if !bytes.Equal(steps[i].msg, want[i].msg) || steps[i].wait != want[i].wait {
+			t.Errorf("step %v: got %+v, want %+v", i, steps[i], want[i])
+		}
+	}
+
+	for _, args := range [][]string{
+		// unquoted arguments are separate messages
+		{"noteon", "ch=1", "key=C4", "vel=100"},
+		// hex bytes start with a status byte
+		{"3C 64"},
+		{"90 3C 6"},
+		{"nope"},
+		{"wait 5 parsecs"},
+		{filepath.Join(dir, "missing.syx")},
+	} {
+		if _, err := parseSteps(args); err == nil {
+			t.Errorf("parseSteps(%q): expected error", args)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	var events []string
+	send := func(msg []byte) error {
+		events = append(events, fmt.Sprintf("% X", msg))
+		return nil
+	}
+	sleep := func(d time.Duration) {
+		events = append(events, d.String())
+	}
+
+	steps := []step{
+		{msg: []byte{0x90, 0x3C, 0x64}},
+		{msg: []byte{0x80, 0x3C, 0x00}},
+		{wait: time.Second},
+		{msg: []byte{0xFA}},
+	}
+
+	if err := play(send, sleep, steps, 2, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// the delay is only applied between two messages, not after a pause
+	want := "90 3C 64,10ms,80 3C 00,1s,FA,10ms,90 3C 64,10ms,80 3C 00,1s,FA"
+	if got := strings.Join(events, ","); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestReadSysEx(t *testing.T) {
+	dir, err := ioutil.TempDir("", "midisend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data []byte) string {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	msgs, err := readSysEx(write("two.syx", []byte{0xF0, 0x43, 0x10, 0xF7, 0xF0, 0xF7}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || !bytes.Equal(msgs[0], []byte{0xF0, 0x43, 0x10, 0xF7}) || !bytes.Equal(msgs[1], []byte{0xF0, 0xF7}) {
+		t.Errorf("got % X", msgs)
+	}
+
+	msgs, err = readSysEx(write("empty.syx", nil))
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("empty file: %v, %v", msgs, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"unterminated.syx": {0xF0, 0x43, 0x10},
+		"garbage.syx":      {0x90, 0x3C, 0x64},
+		"trailing.syx":     {0xF0, 0xF7, 0x00},
+	} {
+		if _, err := readSysEx(write(name, data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package rtmididrv
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/minikomi/rtmididrv/imported/rtmidi"
+)
+
+// portKey identifies a native MIDI port of one direction.
+type portKey struct {
+	number int
+	name   string
+}
+
+// inConn is the native connection to a MIDI in port that is shared by all open handles of that port.
+type inConn struct {
+	// stats must be the first field to be 64-bit aligned for atomic access
+	stats portStats
+	// logged counts the messages for the sampling of the logger (accessed atomically)
+	logged uint64
+	// acquired is 1 while the native port is open (accessed atomically)
+	acquired int32
+
+	driver *Driver
+	key    portKey
+	sync.Mutex
+	midiIn rtmidi.MIDIIn
+	refs   int
+	// pending counts the handles that are acquiring the connection (guarded by the lock of the driver)
+	pending int
+
+	// stop stops the dispatch goroutine, if messages are queued
+	stop chan struct{}
+
+	// listeners holds the []inListener of the handles that are listening.
+	// It is replaced on change, so that the callback can read it without locking.
+	listeners atomic.Value
+	// sensor holds the *sensor that supervises active sensing while the native callback is set
+	sensor atomic.Value
+}
+
+type inListener struct {
+	handle   *in
+	listener func(data []byte, timestampMicroseconds, deltaMicroseconds int64)
+}
+
+// inConn returns the shared connection for the given MIDI in port. The lock of the driver must be held.
+func (d *Driver) inConn(number int, name string) *inConn {
+	k := portKey{number, name}
+	c, has := d.inConns[k]
+	if !has {
+		c = &inConn{driver: d, key: k}
+		d.inConns[k] = c
+	}
+	return c
+}
+
+// acquireInConn acquires the shared connection for the given MIDI in port.
+// The driver only keeps connections while they are referenced (see pruneInConn).
+func (d *Driver) acquireInConn(ctx context.Context, number int, name string) (*inConn, error) {
+	d.Lock()
+	c := d.inConn(number, name)
+	c.pending++
+	d.Unlock()
+
+	err := c.acquire(ctx)
+
+	d.Lock()
+	c.pending--
+	d.Unlock()
+
+	if err != nil {
+		d.pruneInConn(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+// pruneInConn removes the connection from the driver, if it is neither referenced nor being acquired.
+func (d *Driver) pruneInConn(c *inConn) {
+	d.Lock()
+	defer d.Unlock()
+	if c.pending > 0 || d.inConns[c.key] != c {
+		return
+	}
+	c.Lock()
+	unused := c.refs == 0
+	c.Unlock()
+	if unused {
+		delete(d.inConns, c.key)
+	}
+}
+
+// acquire opens the native port, if this is the first reference to it.
+func (c *inConn) acquire(ctx context.Context) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.refs > 0 {
+		c.refs++
+		return nil
+	}
+
+	var midiIn rtmidi.MIDIIn
+
+	err := callContext(ctx, func() error {
+		m, err := rtmidi.NewMIDIInDefault()
+		if err != nil {
+			return fmt.Errorf("can't open default MIDI in: %v", err)
+		}
+		c.driver.logWarnings(m, "in", c.key)
+
+		err = m.OpenPort(c.key.number, "")
+		if err != nil {
+			m.Destroy()
+			return fmt.Errorf("can't open MIDI in port %v (%s): %v", c.key.number, c.key.name, err)
+		}
+
+		d := c.driver
+		// active sensing is filtered in dispatch, if it is supervised
+		err = m.IgnoreTypes(d.ignoreSysEx, d.ignoreTiming, d.ignoreActiveSense && d.sensingHandler == nil)
+		if err != nil {
+			m.Close()
+			m.Destroy()
+			return fmt.Errorf("can't set ignored types of MIDI in port %v (%s): %v", c.key.number, c.key.name, err)
+		}
+		midiIn = m
+		return nil
+	}, func(err error) {
+		if err == nil {
+			midiIn.Destroy()
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	c.midiIn = midiIn
+	c.refs = 1
+	atomic.StoreInt32(&c.acquired, 1)
+	c.driver.logPort("port opened", "in", c.key)
+	return nil
+}
+
+// release closes the native port, if this was the last reference to it.
+func (c *inConn) release(ctx context.Context) error {
+	c.Lock()
+	c.refs--
+	if c.refs > 0 {
+		c.Unlock()
+		return nil
+	}
+	midiIn := c.midiIn
+	c.midiIn = nil
+	atomic.StoreInt32(&c.acquired, 0)
+	c.listeners.Store([]inListener(nil))
+	c.stopDispatch()
+	c.stopSensor()
+	c.Unlock()
+
+	c.driver.logPort("port closed", "in", c.key)
+
+	return c.driver.closeContext(ctx, func() error {
+		// closing cancels the callback
+		err := midiIn.Close()
+		midiIn.Destroy()
+		return err
+	})
+}
+
+// addListener lets the handle listen. The native callback is set for the first listener.
+func (c *inConn) addListener(handle *in, listener func(data []byte, timestampMicroseconds, deltaMicroseconds int64)) error {
+	c.Lock()
+	defer c.Unlock()
+
+	old, _ := c.listeners.Load().([]inListener)
+	for _, l := range old {
+		if l.handle == handle {
+			return fmt.Errorf("listener already set")
+		}
+	}
+
+	ls := make([]inListener, len(old), len(old)+1)
+	copy(ls, old)
+	ls = append(ls, inListener{handle: handle, listener: listener})
+	c.listeners.Store(ls)
+
+	if len(old) > 0 {
+		return nil
+	}
+
+	var err error
+
+	// a new timestamper and queue for every callback, since a late call of the former callback might still run
+	ts := newTimestamper(c.driver)
+
+	if c.driver.sensingHandler != nil {
+		c.sensor.Store(newSensor(c.key.name, ActiveSensingTimeout, c.driver.sensingHandler))
+	}
+
+	switch {
+	case c.driver.queueCapacity > 0:
+		q := newQueue(c.driver.queueCapacity, &c.stats)
+		err = c.midiIn.SetBufferedCallback(func(m rtmidi.MIDIIn, bt []byte, deltaSeconds float64) {
+			c.stats.count(bt)
+			c.sense(bt)
+			abs, delta := ts.stamp(m, deltaSeconds)
+			q.push(bt, abs, delta)
+		})
+		if err == nil {
+			c.stop = make(chan struct{})
+			go q.consume(c.stop, c.dispatchQueued)
+		}
+	case c.driver.reuseInputBuffers:
+		err = c.midiIn.SetBufferedCallback(func(m rtmidi.MIDIIn, bt []byte, deltaSeconds float64) {
+			c.stats.count(bt)
+			c.sense(bt)
+			abs, delta := ts.stamp(m, deltaSeconds)
+			c.dispatch(bt, abs, delta)
+		})
+	default:
+		err = c.midiIn.SetCallback(func(m rtmidi.MIDIIn, bt []byte, deltaSeconds float64) {
+			c.stats.count(bt)
+			c.sense(bt)
+			abs, delta := ts.stamp(m, deltaSeconds)
+			c.dispatch(bt, abs, delta)
+		})
+	}
+
+	if err != nil {
+		c.listeners.Store(old)
+		c.stopSensor()
+		return err
+	}
+
+	return nil
+}
+
+// stopDispatch stops the dispatch goroutine, if there is one. The messages left in the queue are discarded.
+func (c *inConn) stopDispatch() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+// stopSensor stops the supervision of active sensing, if there is one.
+func (c *inConn) stopSensor() {
+	if s, _ := c.sensor.Load().(*sensor); s != nil {
+		s.stop()
+		c.sensor.Store((*sensor)(nil))
+	}
+}
+
+// removeListener stops the handle from listening. The native callback is canceled with the last listener.
+func (c *inConn) removeListener(handle *in) error {
+	c.Lock()
+	defer c.Unlock()
+
+	old, _ := c.listeners.Load().([]inListener)
+	ls := make([]inListener, 0, len(old))
+	for _, l := range old {
+		if l.handle != handle {
+			ls = append(ls, l)
+		}
+	}
+
+	if len(ls) == len(old) {
+		return nil
+	}
+
+	c.listeners.Store(ls)
+
+	if len(ls) > 0 || c.midiIn == nil {
+		return nil
+	}
+
+	c.stopDispatch()
+	c.stopSensor()
+	return c.midiIn.CancelCallback()
+}
+
+// sense passes an incoming message to the supervision of active sensing, if there is one.
+// It is called by the native callback, so that slow listeners or a full queue don't look like a lost connection.
+func (c *inConn) sense(data []byte) {
+	if s, _ := c.sensor.Load().(*sensor); s != nil {
+		s.seen(data)
+	}
+}
+
+// dispatchQueued passes a message from the queue to the listeners on the dispatch goroutine.
+func (c *inConn) dispatchQueued(data []byte, timestampMicroseconds, deltaMicroseconds int64) {
+	if !c.driver.reuseInputBuffers {
+		// the listeners may keep the data
+		data = append([]byte(nil), data...)
+	}
+	c.dispatch(data, timestampMicroseconds, deltaMicroseconds)
+}
+
+// dispatch passes an incoming message to all listeners.
+func (c *inConn) dispatch(data []byte, timestampMicroseconds, deltaMicroseconds int64) {
+	c.driver.logMessage("message received", c.key, &c.logged, data, timestampMicroseconds, deltaMicroseconds)
+	if c.driver.sensingHandler != nil && c.driver.ignoreActiveSense && len(data) == 1 && data[0] == 0xFE {
+		// only received for the supervision
+		return
+	}
+	ls, _ := c.listeners.Load().([]inListener)
+	for _, l := range ls {
+		c.call(l, data, timestampMicroseconds, deltaMicroseconds)
+	}
+}
+
+// call calls the listener. A panic of the listener is recovered and reported to the error handler
+// of the driver, as is exceeding the listener budget.
+func (c *inConn) call(l inListener, data []byte, timestampMicroseconds, deltaMicroseconds int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&c.stats.errors, 1)
+			c.driver.reportError(&ListenerPanic{Port: c.key.name, Value: r, Stack: debug.Stack()})
+		}
+	}()
+
+	budget := c.driver.listenerBudget
+	if budget <= 0 {
+		l.listener(data, timestampMicroseconds, deltaMicroseconds)
+		return
+	}
+
+	start := time.Now()
+	l.listener(data, timestampMicroseconds, deltaMicroseconds)
+	if took := time.Since(start); took > budget {
+		atomic.AddUint64(&c.stats.slowListeners, 1)
+		c.driver.reportError(&SlowListener{Port: c.key.name, Took: took, Budget: budget})
+	}
+}
+
+// outConn is the native connection to a MIDI out port that is shared by all open handles of that port.
+type outConn struct {
+	// stats must be the first field to be 64-bit aligned for atomic access
+	stats portStats
+	// logged counts the messages for the sampling of the logger (accessed atomically)
+	logged uint64
+	// acquired is 1 while the native port is open (accessed atomically)
+	acquired int32
+
+	driver *Driver
+	key    portKey
+	sync.Mutex
+	midiOut rtmidi.MIDIOut
+	refs    int
+	// pending counts the handles that are acquiring the connection (guarded by the lock of the driver)
+	pending int
+
+	// lastSent is the time of the last message sent
+	lastSent time.Time
+	// sensingStop stops sending active sensing
+	sensingStop chan struct{}
+}
+
+// outConn returns the shared connection for the given MIDI out port. The lock of the driver must be held.
+func (d *Driver) outConn(number int, name string) *outConn {
+	k := portKey{number, name}
+	c, has := d.outConns[k]
+	if !has {
+		c = &outConn{driver: d, key: k}
+		d.outConns[k] = c
+	}
+	return c
+}
+
+// acquireOutConn acquires the shared connection for the given MIDI out port.
+// The driver only keeps connections while they are referenced (see pruneOutConn).
+func (d *Driver) acquireOutConn(ctx context.Context, number int, name string) (*outConn, error) {
+	d.Lock()
+	c := d.outConn(number, name)
+	c.pending++
+	d.Unlock()
+
+	err := c.acquire(ctx)
+
+	d.Lock()
+	c.pending--
+	d.Unlock()
+
+	if err != nil {
+		d.pruneOutConn(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+// pruneOutConn removes the connection from the driver, if it is neither referenced nor being acquired.
+func (d *Driver) pruneOutConn(c *outConn) {
+	d.Lock()
+	defer d.Unlock()
+	if c.pending > 0 || d.outConns[c.key] != c {
+		return
+	}
+	c.Lock()
+	unused := c.refs == 0
+	c.Unlock()
+	if unused {
+		delete(d.outConns, c.key)
+	}
+}
+
+// acquire opens the native port, if this is the first reference to it.
+func (c *outConn) acquire(ctx context.Context) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.refs > 0 {
+		c.refs++
+		return nil
+	}
+
+	var midiOut rtmidi.MIDIOut
+
+	err := callContext(ctx, func() error {
+		m, err := rtmidi.NewMIDIOutDefault()
+		if err != nil {
+			return fmt.Errorf("can't open default MIDI out: %v", err)
+		}
+		c.driver.logWarnings(m, "out", c.key)
+
+		err = m.OpenPort(c.key.number, "")
+		if err != nil {
+			m.Destroy()
+			return fmt.Errorf("can't open MIDI out port %v (%s): %v", c.key.number, c.key.name, err)
+		}
+		midiOut = m
+		return nil
+	}, func(err error) {
+		if err == nil {
+			midiOut.Destroy()
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	c.midiOut = midiOut
+	c.refs = 1
+	atomic.StoreInt32(&c.acquired, 1)
+	c.driver.logPort("port opened", "out", c.key)
+	return nil
+}
+
+// release closes the native port, if this was the last reference to it.
+func (c *outConn) release(ctx context.Context) error {
+	c.Lock()
+	c.refs--
+	if c.refs > 0 {
+		c.Unlock()
+		return nil
+	}
+	midiOut := c.midiOut
+	c.midiOut = nil
+	atomic.StoreInt32(&c.acquired, 0)
+	c.stopSensing()
+	c.Unlock()
+
+	c.driver.logPort("port closed", "out", c.key)
+
+	return c.driver.closeContext(ctx, func() error {
+		err := midiOut.Close()
+		midiOut.Destroy()
+		return err
+	})
+}
+
+// send sends the message. Messages of different handles are never interleaved.
+func (c *outConn) send(b []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.sendLocked(b)
+}
+
+// sendLocked sends the message. It must be called with the lock held.
+func (c *outConn) sendLocked(b []byte) error {
+	if c.midiOut == nil {
+		return connect.ErrClosed
+	}
+	err := c.midiOut.SendMessage(b)
+	if err != nil {
+		atomic.AddUint64(&c.stats.errors, 1)
+		return err
+	}
+	c.lastSent = time.Now()
+	c.stats.count(b)
+	c.driver.logMessage("message sent", c.key, &c.logged, b, c.driver.Now(), -1)
+	return nil
+}
@@ -0,0 +1,178 @@
+package rtmididrv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomidi/connect"
+)
+
+func TestListenerPanicAndBudget(t *testing.T) {
+	var errs []error
+	d, _ := New(ErrorHandler(func(err error) { errs = append(errs, err) }), ListenerBudget(time.Millisecond))
+	c := d.inConn(0, "test")
+
+	var called int
+	c.listeners.Store([]inListener{
+		{listener: func([]byte, int64, int64) { panic("boom") }},
+		{listener: func([]byte, int64, int64) { time.Sleep(5 * time.Millisecond) }},
+		{listener: func([]byte, int64, int64) { called++ }},
+	})
+
+	c.dispatch([]byte{0x90, 60, 100}, 0, 0)
+
+	if called != 1 {
+		t.Errorf("listener after the panicking one should have been called once, got %v", called)
+	}
+
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+
+	if p, ok := errs[0].(*ListenerPanic); !ok || p.Value != "boom" || p.Port != "test" {
+		t.Errorf("expected *ListenerPanic with value boom, got %#v", errs[0])
+	}
+
+	if _, ok := errs[1].(*SlowListener); !ok {
+		t.Errorf("expected *SlowListener, got %#v", errs[1])
+	}
+
+	if c.stats.slowListeners != 1 || c.stats.errors != 1 {
+		t.Errorf("expected 1 slow listener and 1 error, got %v and %v", c.stats.slowListeners, c.stats.errors)
+	}
+}
+
+func TestCloseExpiredContext(t *testing.T) {
+	d, _ := New(ErrorHandler(func(error) {}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fin, fout := newFakeMIDI(), newFakeMIDI()
+	i := openIn(d, 0, "in", fin)
+	o := openOut(d, 0, "out", fout)
+
+	// the error is either nil or wraps ctx.Err(), depending on what comes first
+	if err := i.CloseContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("closing in port: %v", err)
+	}
+	if err := o.CloseContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("closing out port: %v", err)
+	}
+
+	fin.released(t)
+	fout.released(t)
+
+	if i.IsOpen() || o.IsOpen() || len(d.OpenPorts()) != 0 {
+		t.Errorf("ports still open: %v", d.OpenPorts())
+	}
+}
+
+func TestCloseOutDoesNotWait(t *testing.T) {
+	d, _ := New()
+	f := newFakeMIDI()
+	o := openOut(d, 0, "out", f)
+
+	start := time.Now()
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Errorf("closing took %v", took)
+	}
+	f.released(t)
+}
+
+func TestSharedInConn(t *testing.T) {
+	d, _ := New(QueueCapacity(0))
+	f := newFakeMIDI()
+
+	h1 := openIn(d, 0, "Keyboard", f)
+	h2 := h1.Handle()
+	if err := h2.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got1, got2 int
+	if err := h1.SetListener(func([]byte, int64) { got1++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := h2.SetListener(func([]byte, int64) { got2++ }); err != nil {
+		t.Fatal(err)
+	}
+
+	f.receive(t, []byte{0x90, 60, 100})
+	if got1 != 1 || got2 != 1 {
+		t.Errorf("both handles should get the message, got %v and %v", got1, got2)
+	}
+
+	// closing one handle keeps the native port and the callback for the other
+	if err := h1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Lock()
+	closed := f.closed
+	f.Unlock()
+	if closed != 0 {
+		t.Fatalf("native port closed while a handle is open")
+	}
+
+	f.receive(t, []byte{0x80, 60, 0})
+	if got1 != 1 || got2 != 2 {
+		t.Errorf("after closing the first handle, got %v and %v", got1, got2)
+	}
+
+	// the native callback is canceled with the last listener
+	if err := h2.StopListening(); err != nil {
+		t.Fatal(err)
+	}
+	f.Lock()
+	cb := f.callback
+	f.Unlock()
+	if cb != nil {
+		t.Errorf("callback still set without listeners")
+	}
+
+	if err := h2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.released(t)
+}
+
+func TestSharedOutConn(t *testing.T) {
+	d, _ := New()
+	f := newFakeMIDI()
+
+	h1 := openOut(d, 0, "Synth", f)
+	h2 := h1.Handle()
+	if err := h2.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h1.Send([]byte{0x90, 60, 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h1.Send([]byte{0x80, 60, 0}); err != connect.ErrClosed {
+		t.Errorf("sending to a closed handle: %v", err)
+	}
+	if err := h2.Send([]byte{0x80, 60, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Lock()
+	sent := len(f.sent)
+	f.Unlock()
+	if sent != 2 {
+		t.Errorf("%v messages sent", sent)
+	}
+
+	if err := h2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.released(t)
+}
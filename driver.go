@@ -1,124 +1,395 @@
 package rtmididrv
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gomidi/connect"
 	"github.com/minikomi/rtmididrv/imported/rtmidi"
 	//	"github.com/metakeule/mutex"
 )
 
-type driver struct {
-	debug  bool
-	opened []connect.Port
+// Driver is a connect.Driver based on rtmidi.
+type Driver struct {
+	// debug is set, if there is a logger
+	debug bool
+	// logger gets the log records (nil means no logging)
+	logger LogHandler
+	// logSampling lets only every n-th message of a port be logged
+	logSampling uint64
+	// logLimiter limits the logged messages per second
+	logLimiter logRateLimiter
+
+	// reuseInputBuffers lets the listeners get the messages in reused buffers
+	reuseInputBuffers bool
+	// queueCapacity is the capacity of the queues of the in ports (0 means no queue)
+	queueCapacity int
+	// errorHandler gets the errors that can't be returned, e.g. panics of listeners
+	errorHandler func(error)
+	// listenerBudget is the time a listener may take per message (0 means no limit)
+	listenerBudget time.Duration
+	// timestampSource is the source of the timestamps of incoming messages
+	timestampSource TimestampSource
+	// ignoreSysEx, ignoreTiming and ignoreActiveSense let the in ports ignore these messages
+	ignoreSysEx, ignoreTiming, ignoreActiveSense bool
+	// sensingHandler gets the changes of the connection state of the in ports that get active sensing (nil means no supervision)
+	sensingHandler func(SensingEvent)
+	// epoch is the start of the monotonic time base of the timestamps
+	epoch time.Time
+
+	// opened is the registry of the open ports, in the order they were opened
+	opened []Port
+	// inConns and outConns hold the native connections that are shared by the handles of a port
+	inConns  map[portKey]*inConn
+	outConns map[portKey]*outConn
+
+	// enumMx guards the clients that are reused to enumerate the ports
+	enumMx  sync.Mutex
+	enumIn  rtmidi.MIDIIn
+	enumOut rtmidi.MIDIOut
 	sync.RWMutex
 	//	mutex.RWMutex
-	closed bool
 }
 
-func (d *driver) String() string {
+// Port is implemented by the MIDI in and out ports of the driver.
+type Port interface {
+	connect.Port
+
+	// OpenContext is like Open, but gives up when ctx is done.
+	OpenContext(ctx context.Context) error
+
+	// CloseContext is like Close, but gives up when ctx is done.
+	CloseContext(ctx context.Context) error
+
+	// Stats returns the traffic counters of the port.
+	Stats() Stats
+}
+
+// In is implemented by the MIDI in ports of the driver. Use it with type casting:
+//   rtIn := i.(rtmididrv.In)
+type In interface {
+	connect.In
+	Port
+
+	// Handle returns a new handle to the same MIDI in port.
+	Handle() In
+
+	// SetTimestampedListener is like SetListener, but the listener also gets the timestamp of the message.
+	SetTimestampedListener(listener func(data []byte, timestampMicroseconds, deltaMicroseconds int64)) error
+
+	// Dropped returns the number of incoming messages that have been dropped, because the queue of the port was full.
+	Dropped() uint64
+
+	// SlowListeners returns the number of listener calls that exceeded the listener budget.
+	SlowListeners() uint64
+}
+
+// Out is implemented by the MIDI out ports of the driver. Use it with type casting:
+//   rtOut := o.(rtmididrv.Out)
+type Out interface {
+	connect.Out
+	Port
+
+	// Handle returns a new handle to the same MIDI out port.
+	Handle() Out
+
+	// SendActiveSensing lets the port send active sensing whenever nothing has been sent for the interval.
+	SendActiveSensing(interval time.Duration) error
+}
+
+// PortErrors is returned when one or more ports failed. It has one error per failing port.
+type PortErrors []error
+
+// Error returns the messages of all errors.
+func (p PortErrors) Error() string {
+	msgs := make([]string, len(p))
+	for i, err := range p {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (d *Driver) String() string {
 	return "rtmididrv"
 }
 
-// Close closes all open ports. It must be called at the end of a session.
-func (d *driver) Close() (err error) {
+// register adds p to the open ports, if it is not already there.
+func (d *Driver) register(p Port) {
+	d.Lock()
+	defer d.Unlock()
+	for _, o := range d.opened {
+		if o == p {
+			return
+		}
+	}
+	d.opened = append(d.opened, p)
+}
+
+// unregister removes p from the open ports.
+func (d *Driver) unregister(p Port) {
+	d.Lock()
+	defer d.Unlock()
+	for idx, o := range d.opened {
+		if o == p {
+			d.opened = append(d.opened[:idx], d.opened[idx+1:]...)
+			return
+		}
+	}
+}
 
+// OpenPorts returns the ports that are currently open, in the order they were opened.
+func (d *Driver) OpenPorts() []Port {
 	d.RLock()
-	if d.closed {
-		d.RUnlock()
-		return connect.ErrClosed
+	defer d.RUnlock()
+	ports := make([]Port, len(d.opened))
+	copy(ports, d.opened)
+	return ports
+}
+
+// Close closes all open ports and frees the clients used for enumerating ports.
+// It must be called at the end of a session.
+// The driver and its ports may be used again afterwards.
+func (d *Driver) Close() error {
+	return d.CloseContext(context.Background())
+}
+
+// CloseContext closes all open ports. Native calls that are still running when ctx is done
+// are abandoned and the ports are reported as failed.
+// All ports count as closed afterwards in any case and the driver may be used again.
+// If some ports could not be closed, the returned error is of type PortErrors.
+func (d *Driver) CloseContext(ctx context.Context) error {
+	opened := d.OpenPorts()
+
+	var errs PortErrors
+
+	for _, p := range opened {
+		err := p.CloseContext(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't close %s: %v", p, err))
+		}
 	}
 
-	d.RUnlock()
-	d.Lock()
-	d.closed = true
-	d.Unlock()
-
-	for _, p := range d.opened {
-		err = p.Close()
-		// don't destroy, this just panics
-		/*
-			u := p.Underlying()
-			switch v := u.(type) {
-			case rtmidi.MIDIIn:
-				v.Destroy()
-			case rtmidi.MIDIOut:
-				v.Destroy()
-			}
-		*/
+	d.log(LogInfo, "driver closed", LogAttr{"ports", len(opened)}, LogAttr{"errors", len(errs)})
+
+	d.enumMx.Lock()
+	if d.enumIn != nil {
+		d.enumIn.Destroy()
+		d.enumIn = nil
 	}
+	if d.enumOut != nil {
+		d.enumOut.Destroy()
+		d.enumOut = nil
+	}
+	d.enumMx.Unlock()
 
-	// return just the last error to allow closing the other ports.
-	// to ensure that all ports have been closed, this function must
-	// return nil anyways
-	return
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Option is an option for the driver.
+type Option func(*Driver)
+
+// ReuseInputBuffers lets the listeners of the MIDI in ports get the incoming messages in buffers
+// that are reused for the next message of the port, so that no memory is allocated per message.
+// The data passed to a listener is only valid until the listener returns; it must not be modified
+// or retained and has to be copied if it is needed afterwards.
+func ReuseInputBuffers() Option {
+	return func(d *Driver) {
+		d.reuseInputBuffers = true
+	}
+}
+
+// QueueCapacity sets the number of incoming messages that each MIDI in port buffers between the
+// native input thread and the listeners. The listeners are called by a goroutine of the port,
+// so that a slow listener does not block the native input. If the buffer is full, incoming messages
+// are dropped and counted (see In.Dropped).
+// A capacity of 0 disables the buffer: the listeners are then called on the native input thread.
+// The default capacity is DefaultQueueCapacity.
+func QueueCapacity(capacity int) Option {
+	return func(d *Driver) {
+		d.queueCapacity = capacity
+	}
+}
+
+// ErrorHandler sets the function that gets the errors that can't be returned to a caller,
+// like panics of listeners (*ListenerPanic) and listeners that exceed their budget (*SlowListener).
+// The handler may be called concurrently from different ports.
+// By default, these errors are written to the standard logger.
+func ErrorHandler(handler func(error)) Option {
+	return func(d *Driver) {
+		d.errorHandler = handler
+	}
+}
+
+// ListenerBudget sets the time that a listener may take per message. Every call that takes longer is counted
+// (see In.SlowListeners) and reported to the error handler as *SlowListener.
+// By default, the time is not measured.
+func ListenerBudget(budget time.Duration) Option {
+	return func(d *Driver) {
+		d.listenerBudget = budget
+	}
+}
+
+// IgnoreTypes sets which incoming messages the MIDI in ports ignore: SysEx messages,
+// timing messages (MIDI time code and clock) and active sensing.
+// By default, all of them are ignored, as rtmidi does.
+func IgnoreTypes(sysex, timing, activeSense bool) Option {
+	return func(d *Driver) {
+		d.ignoreSysEx, d.ignoreTiming, d.ignoreActiveSense = sysex, timing, activeSense
+	}
+}
+
+// Timestamps sets the source of the timestamps of incoming messages. The default is TimestampBackend.
+func Timestamps(source TimestampSource) Option {
+	return func(d *Driver) {
+		d.timestampSource = source
+	}
 }
 
 // New returns a driver based on the default rtmidi in and out
 //func New(debug bool) (connect.Driver, error) {
-func New() (connect.Driver, error) {
-	//d := &driver{debug: debug}
-	d := &driver{}
+func New(options ...Option) (*Driver, error) {
+	//d := &Driver{debug: debug}
+	d := &Driver{
+		queueCapacity:     DefaultQueueCapacity,
+		ignoreSysEx:       true,
+		ignoreTiming:      true,
+		ignoreActiveSense: true,
+		epoch:             time.Now(),
+		inConns:           map[portKey]*inConn{},
+		outConns:          map[portKey]*outConn{},
+	}
+	for _, opt := range options {
+		opt(d)
+	}
 	//	d.RWMutex = mutex.NewRWMutex("rtmididrv driver", debug)
 	return d, nil
 }
 
-// Ins returns the available MIDI input ports
-func (d *driver) Ins() (ins []connect.In, err error) {
-	d.Lock()
-	defer d.Unlock()
+// reportError passes err to the error handler.
+func (d *Driver) reportError(err error) {
+	d.log(LogError, "error", LogAttr{"error", err})
+	if d.errorHandler == nil {
+		log.Printf("rtmididrv: %v", err)
+		return
+	}
+	d.errorHandler(err)
+}
 
-	if d.closed {
-		return nil, connect.ErrClosed
+// closeContext runs fn, that closes a native port, and waits until it returns or ctx is done, whatever comes first.
+// Unlike callContext, fn is run even if ctx is already done, so that the native port is always released.
+// If ctx is done before fn returns, fn finishes in the background and its error is passed to the error handler.
+func (d *Driver) closeContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
 	}
-	in, err := rtmidi.NewMIDIInDefault()
-	if err != nil {
-		return nil, fmt.Errorf("can't open default MIDI in: %v", err)
+
+	res := make(chan error, 1)
+	go func() {
+		res <- fn()
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		go func() {
+			if err := <-res; err != nil {
+				d.reportError(err)
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// callContext runs fn and waits until it returns or ctx is done, whatever comes first.
+// If ctx is done before, fn keeps running in the background and abandon is called with its result.
+func callContext(ctx context.Context, fn func() error, abandon func(error)) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	res := make(chan error, 1)
+	go func() {
+		res <- fn()
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		if abandon != nil {
+			go func() {
+				abandon(<-res)
+			}()
+		}
+		return ctx.Err()
+	}
+}
+
+// Ins returns the available MIDI input ports
+func (d *Driver) Ins() (ins []connect.In, err error) {
+	d.enumMx.Lock()
+	defer d.enumMx.Unlock()
+
+	if d.enumIn == nil {
+		d.enumIn, err = rtmidi.NewMIDIInDefault()
+		if err != nil {
+			return nil, fmt.Errorf("can't open default MIDI in: %v", err)
+		}
+		d.logWarnings(d.enumIn, "in", portKey{number: -1})
 	}
 
-	ports, err := in.PortCount()
+	ports, err := d.enumIn.PortCount()
 	if err != nil {
 		return nil, fmt.Errorf("can't get number of in ports: %s", err.Error())
 	}
 
 	for i := 0; i < ports; i++ {
-		name, err := in.PortName(i)
+		name, err := d.enumIn.PortName(i)
 		if err != nil {
 			name = ""
 		}
 		ins = append(ins, newIn(d.debug, d, i, name))
 	}
 
-	//in.Destroy()
 	return
 }
 
 // Outs returns the available MIDI output ports
-func (d *driver) Outs() (outs []connect.Out, err error) {
-	d.Lock()
-	defer d.Unlock()
+func (d *Driver) Outs() (outs []connect.Out, err error) {
+	d.enumMx.Lock()
+	defer d.enumMx.Unlock()
 
-	if d.closed {
-		return nil, connect.ErrClosed
-	}
-	out, err := rtmidi.NewMIDIOutDefault()
-	if err != nil {
-		return nil, fmt.Errorf("can't open default MIDI out: %v", err)
+	if d.enumOut == nil {
+		d.enumOut, err = rtmidi.NewMIDIOutDefault()
+		if err != nil {
+			return nil, fmt.Errorf("can't open default MIDI out: %v", err)
+		}
+		d.logWarnings(d.enumOut, "out", portKey{number: -1})
 	}
 
-	ports, err := out.PortCount()
+	ports, err := d.enumOut.PortCount()
 	if err != nil {
 		return nil, fmt.Errorf("can't get number of out ports: %s", err.Error())
 	}
 
 	for i := 0; i < ports; i++ {
-		name, err := out.PortName(i)
+		name, err := d.enumOut.PortName(i)
 		if err != nil {
 			name = ""
 		}
 		outs = append(outs, newOut(d.debug, d, i, name))
 	}
-	//out.Destroy()
 	return
 }
@@ -0,0 +1,275 @@
+package rtmididrv
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minikomi/rtmididrv/imported/rtmidi"
+)
+
+// fakeMIDI is a native port for tests. It implements rtmidi.MIDIIn and rtmidi.MIDIOut.
+type fakeMIDI struct {
+	sync.Mutex
+	closed    int
+	destroyed int
+	sent      [][]byte
+	callback  func(rtmidi.MIDIIn, []byte, float64)
+	// closeErr is returned by Close
+	closeErr error
+	// done is closed when the port is destroyed
+	done chan struct{}
+}
+
+func newFakeMIDI() *fakeMIDI {
+	return &fakeMIDI{done: make(chan struct{})}
+}
+
+func (f *fakeMIDI) OpenPort(int, string) error                 { return nil }
+func (f *fakeMIDI) OpenVirtualPort(string) error               { return nil }
+func (f *fakeMIDI) PortCount() (int, error)                    { return 1, nil }
+func (f *fakeMIDI) PortName(int) (string, error)               { return "fake", nil }
+func (f *fakeMIDI) SetWarningHandler(func(string, bool)) error { return nil }
+func (f *fakeMIDI) API() (rtmidi.API, error)                   { return rtmidi.APIUnspecified, nil }
+func (f *fakeMIDI) IgnoreTypes(bool, bool, bool) error         { return nil }
+func (f *fakeMIDI) Message() ([]byte, float64, error)          { return nil, 0, nil }
+func (f *fakeMIDI) SourceTime() (float64, bool)                { return 0, false }
+
+func (f *fakeMIDI) SetCallback(cb func(rtmidi.MIDIIn, []byte, float64)) error {
+	f.Lock()
+	f.callback = cb
+	f.Unlock()
+	return nil
+}
+
+func (f *fakeMIDI) SetBufferedCallback(cb func(rtmidi.MIDIIn, []byte, float64)) error {
+	return f.SetCallback(cb)
+}
+
+func (f *fakeMIDI) CancelCallback() error {
+	return f.SetCallback(nil)
+}
+
+func (f *fakeMIDI) SendMessage(b []byte) error {
+	f.Lock()
+	f.sent = append(f.sent, append([]byte(nil), b...))
+	f.Unlock()
+	return nil
+}
+
+func (f *fakeMIDI) Close() error {
+	f.Lock()
+	f.closed++
+	f.Unlock()
+	return f.closeErr
+}
+
+func (f *fakeMIDI) Destroy() {
+	f.Lock()
+	f.destroyed++
+	if f.destroyed == 1 {
+		close(f.done)
+	}
+	f.Unlock()
+}
+
+// released waits until the fake has been closed and destroyed.
+func (f *fakeMIDI) released(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.done:
+	case <-time.After(time.Second):
+		t.Fatalf("native port not destroyed")
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.closed != 1 || f.destroyed != 1 {
+		t.Errorf("native port closed %v times and destroyed %v times", f.closed, f.destroyed)
+	}
+}
+
+// openIn returns an open handle of the in port, as OpenContext does, but with the fake as native port.
+func openIn(d *Driver, number int, name string, f *fakeMIDI) *in {
+	i := newIn(false, d, number, name).(*in)
+	d.Lock()
+	i.conn = d.inConn(number, name)
+	d.Unlock()
+	i.conn.Lock()
+	if i.conn.refs == 0 {
+		i.conn.midiIn = f
+		atomic.StoreInt32(&i.conn.acquired, 1)
+	}
+	i.conn.refs++
+	i.conn.Unlock()
+	i.open = true
+	d.register(i)
+	return i
+}
+
+// openOut returns an open handle of the out port, as OpenContext does, but with the fake as native port.
+func openOut(d *Driver, number int, name string, f *fakeMIDI) *out {
+	o := newOut(false, d, number, name).(*out)
+	d.Lock()
+	o.conn = d.outConn(number, name)
+	d.Unlock()
+	o.conn.Lock()
+	if o.conn.refs == 0 {
+		o.conn.midiOut = f
+		atomic.StoreInt32(&o.conn.acquired, 1)
+	}
+	o.conn.refs++
+	o.conn.Unlock()
+	o.open = true
+	d.register(o)
+	return o
+}
+
+func TestCallContext(t *testing.T) {
+	errFn := errors.New("fn failed")
+
+	err := callContext(context.Background(), func() error { return errFn }, nil)
+	if err != errFn {
+		t.Errorf("without deadline: got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = callContext(ctx, func() error { return errFn }, nil)
+	if err != errFn {
+		t.Errorf("with running ctx: got %v", err)
+	}
+
+	cancel()
+	var called bool
+	err = callContext(ctx, func() error { called = true; return nil }, nil)
+	if err != context.Canceled || called {
+		t.Errorf("with expired ctx: got %v, fn called: %v", err, called)
+	}
+
+	// ctx is done while fn is running: fn finishes in the background and abandon gets its result
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	abandoned := make(chan error, 1)
+	err = callContext(ctx, func() error {
+		<-release
+		return errFn
+	}, func(err error) { abandoned <- err })
+	if err != context.DeadlineExceeded {
+		t.Errorf("with deadline during fn: got %v", err)
+	}
+	close(release)
+	select {
+	case err := <-abandoned:
+		if err != errFn {
+			t.Errorf("abandon got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("abandon not called")
+	}
+}
+
+func TestPortErrors(t *testing.T) {
+	errs := PortErrors{errors.New("can't close a: boom"), errors.New("can't close b: bang")}
+	if got, want := errs.Error(), "can't close a: boom; can't close b: bang"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDriverCloseContext(t *testing.T) {
+	d, _ := New()
+
+	failing := newFakeMIDI()
+	failing.closeErr = errors.New("boom")
+	ok := newFakeMIDI()
+
+	i := openIn(d, 0, "Keyboard", failing)
+	o := openOut(d, 0, "Synth", ok)
+
+	err := d.CloseContext(context.Background())
+	errs, isPortErrors := err.(PortErrors)
+	if !isPortErrors || len(errs) != 1 || !strings.Contains(errs[0].Error(), "Keyboard") || !strings.Contains(errs[0].Error(), "boom") {
+		t.Fatalf("expected PortErrors for Keyboard, got %#v", err)
+	}
+
+	failing.released(t)
+	ok.released(t)
+
+	if i.IsOpen() || o.IsOpen() || len(d.OpenPorts()) != 0 {
+		t.Errorf("ports still open: %v", d.OpenPorts())
+	}
+
+	// the driver may be used again
+	if err := d.Close(); err != nil {
+		t.Errorf("closing twice: %v", err)
+	}
+}
+
+func TestOpenPorts(t *testing.T) {
+	d, _ := New()
+
+	a := openIn(d, 0, "A", newFakeMIDI())
+	b := openOut(d, 0, "B", newFakeMIDI())
+	c := openIn(d, 1, "C", newFakeMIDI())
+
+	check := func(when string, want ...Port) {
+		t.Helper()
+		got := d.OpenPorts()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v, want %v", when, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", when, got, want)
+			}
+		}
+	}
+
+	check("opened", a, b, c)
+
+	// a second handle keeps the native port of B, so that B can be reopened without native calls
+	b2 := b.Handle()
+	if err := b2.Open(); err != nil {
+		t.Fatal(err)
+	}
+	check("second handle", a, b, c, b2)
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("closing twice: %v", err)
+	}
+	check("closed", a, c, b2)
+
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Open(); err != nil {
+		t.Errorf("opening twice: %v", err)
+	}
+	check("reopened", a, c, b2, b)
+}
+
+// receive passes a message to the native callback of the fake, as the input thread does.
+// It waits for the callback to be set.
+func (f *fakeMIDI) receive(t *testing.T, msg []byte) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.Lock()
+		cb := f.callback
+		f.Unlock()
+		if cb != nil {
+			cb(f, msg, 0)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no callback set")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
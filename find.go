@@ -0,0 +1,85 @@
+package rtmididrv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomidi/connect"
+)
+
+// FindIn returns the MIDI in port that matches spec: either the number of the port, its exact name or
+// a part of its name, ignoring case. It is an error, if a part of a name matches more than one port.
+func (d *Driver) FindIn(spec string) (In, error) {
+	ins, err := d.Ins()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]connect.Port, len(ins))
+	for i, in := range ins {
+		ports[i] = in
+	}
+
+	p, err := findPort(ports, spec)
+	if err != nil {
+		return nil, fmt.Errorf("can't find MIDI in %q: %v", spec, err)
+	}
+	return p.(In), nil
+}
+
+// FindOut returns the MIDI out port that matches spec, like FindIn.
+func (d *Driver) FindOut(spec string) (Out, error) {
+	outs, err := d.Outs()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]connect.Port, len(outs))
+	for i, out := range outs {
+		ports[i] = out
+	}
+
+	p, err := findPort(ports, spec)
+	if err != nil {
+		return nil, fmt.Errorf("can't find MIDI out %q: %v", spec, err)
+	}
+	return p.(Out), nil
+}
+
+func findPort(ports []connect.Port, spec string) (connect.Port, error) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		for _, p := range ports {
+			if p.Number() == n {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("no port with number %v", n)
+	}
+
+	for _, p := range ports {
+		if p.String() == spec {
+			return p, nil
+		}
+	}
+
+	var found []connect.Port
+	for _, p := range ports {
+		if strings.Contains(strings.ToLower(p.String()), strings.ToLower(spec)) {
+			found = append(found, p)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no such port")
+	case 1:
+		return found[0], nil
+	default:
+		names := make([]string, len(found))
+		for i, p := range found {
+			names[i] = fmt.Sprintf("[%v] %s", p.Number(), p)
+		}
+		return nil, fmt.Errorf("ambiguous, matches %s", strings.Join(names, ", "))
+	}
+}
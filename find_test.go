@@ -0,0 +1,41 @@
+package rtmididrv
+
+import (
+	"testing"
+
+	"github.com/gomidi/connect"
+)
+
+func TestFindPort(t *testing.T) {
+	d, _ := New()
+	ports := []connect.Port{
+		newIn(false, d, 0, "USB Keyboard"),
+		newIn(false, d, 1, "Synth A"),
+		newIn(false, d, 2, "Synth A 2"),
+	}
+
+	tests := []struct {
+		spec   string
+		number int
+	}{
+		{"1", 1},
+		{"Synth A", 1},
+		{"keyboard", 0},
+		{"a 2", 2},
+		{"synth", -1},
+		{"7", -1},
+		{"drums", -1},
+	}
+
+	for _, test := range tests {
+		p, err := findPort(ports, test.spec)
+		switch {
+		case test.number < 0 && err == nil:
+			t.Errorf("findPort(%q) = %v, want error", test.spec, p)
+		case test.number >= 0 && err != nil:
+			t.Errorf("findPort(%q): %v", test.spec, err)
+		case test.number >= 0 && p.Number() != test.number:
+			t.Errorf("findPort(%q) = %v, want %v", test.spec, p.Number(), test.number)
+		}
+	}
+}
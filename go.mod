@@ -1,6 +1,6 @@
 module github.com/minikomi/rtmididrv
 
-replace github.com/gomidi/minikomi/imported/rtmidi => ./imported/rtmidi
+replace github.com/minikomi/rtmididrv/imported/rtmidi => ./imported/rtmidi
 
 require (
 	github.com/gomidi/connect v0.11.1
@@ -486,7 +486,14 @@ void MidiApi :: error( RtMidiError::Type type, std::string errorString )
     firstErrorOccurred_ = true;
     const std::string errorMessage = errorString;
 
-    errorCallback_( type, errorMessage, errorCallbackUserData_);
+    try {
+      errorCallback_( type, errorMessage, errorCallbackUserData_);
+    }
+    catch ( ... ) {
+      // the callback may throw to report the error as usual
+      firstErrorOccurred_ = false;
+      throw;
+    }
     firstErrorOccurred_ = false;
     return;
   }
@@ -1622,6 +1629,11 @@ static void *alsaMidiHandler( void *ptr )
 
           apiData->lastTime = ev->time.time;
 
+#ifndef AVOID_TIMESTAMPING
+          // Keep the sequencer real-time of the event for getSourceTime.
+          data->sourceTime = x.tv_sec + x.tv_nsec*1e-9;
+#endif
+
           if ( data->firstMessage == true )
             data->firstMessage = false;
           else
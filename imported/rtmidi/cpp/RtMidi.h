@@ -339,6 +339,15 @@ class RTMIDI_DLL_PUBLIC RtMidiIn : public RtMidi
   */
   double getMessage( std::vector<unsigned char> *message );
 
+  //! Return the time stamp of the message that is currently passed to the callback, as reported by the MIDI API.
+  /*!
+    The time stamp is in seconds on the clock of the MIDI API (e.g. the
+    real-time of the ALSA sequencer queue). A negative value is returned
+    if the API does not provide time stamps. It must only be called
+    from within the callback function.
+  */
+  double getSourceTime( void );
+
   //! Set an error callback function to be invoked when an error has occured.
   /*!
     The callback function will be called whenever an error has occured. It is best
@@ -514,6 +523,7 @@ class RTMIDI_DLL_PUBLIC MidiInApi : public MidiApi
   void cancelCallback( void );
   virtual void ignoreTypes( bool midiSysex, bool midiTime, bool midiSense );
   double getMessage( std::vector<unsigned char> *message );
+  double getSourceTime( void ) { return inputData_.sourceTime; }
 
   // A MIDI structure used internally by the class to store incoming
   // messages.  Each message represents one and only one MIDI message.
@@ -556,12 +566,14 @@ class RTMIDI_DLL_PUBLIC MidiInApi : public MidiApi
     RtMidiIn::RtMidiCallback userCallback;
     void *userData;
     bool continueSysex;
+    //! Time stamp of the API for the message passed to the callback, negative if unknown
+    double sourceTime;
 
     // Default constructor.
   RtMidiInData()
   : ignoreFlags(7), doInput(false), firstMessage(true),
       apiData(0), usingCallback(false), userCallback(0), userData(0),
-      continueSysex(false) {}
+      continueSysex(false), sourceTime(-1.0) {}
   };
 
  protected:
@@ -594,6 +606,7 @@ inline unsigned int RtMidiIn :: getPortCount( void ) { return rtapi_->getPortCou
 inline std::string RtMidiIn :: getPortName( unsigned int portNumber ) { return rtapi_->getPortName( portNumber ); }
 inline void RtMidiIn :: ignoreTypes( bool midiSysex, bool midiTime, bool midiSense ) { ((MidiInApi *)rtapi_)->ignoreTypes( midiSysex, midiTime, midiSense ); }
 inline double RtMidiIn :: getMessage( std::vector<unsigned char> *message ) { return ((MidiInApi *)rtapi_)->getMessage( message ); }
+inline double RtMidiIn :: getSourceTime( void ) { return ((MidiInApi *)rtapi_)->getSourceTime(); }
 inline void RtMidiIn :: setErrorCallback( RtMidiErrorCallback errorCallback, void *userData ) { rtapi_->setErrorCallback(errorCallback, userData); }
 
 inline RtMidi::Api RtMidiOut :: getCurrentApi( void ) throw() { return rtapi_->getCurrentApi(); }
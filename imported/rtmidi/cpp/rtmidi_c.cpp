@@ -42,6 +42,17 @@ class CallbackProxyUserData
 	void *user_data;
 };
 
+class WarningProxyUserData
+{
+  public:
+	WarningProxyUserData (RtMidiCWarningCallback cCallback, void *userData)
+		: c_callback (cCallback), user_data (userData)
+	{
+	}
+	RtMidiCWarningCallback c_callback;
+	void *user_data;
+};
+
 /* RtMidi API */
 int rtmidi_get_compiled_api (enum RtMidiApi *apis, unsigned int apis_size)
 {
@@ -67,6 +78,26 @@ void rtmidi_error (MidiApi *api, enum RtMidiErrorType type, const char* errorStr
 	api->error ((RtMidiError::Type) type, msg);
 }
 
+static
+void warning_proxy (RtMidiError::Type type, const std::string &errorText, void *userData)
+{
+	// errors are thrown as without a callback, so that they end up in the return status
+	if (type != RtMidiError::WARNING && type != RtMidiError::DEBUG_WARNING) {
+		std::cerr << '\n' << errorText << "\n\n";
+		throw RtMidiError (errorText, type);
+	}
+	WarningProxyUserData* data = reinterpret_cast<WarningProxyUserData*> (userData);
+	data->c_callback ((enum RtMidiErrorType) type, errorText.c_str (), data->user_data);
+}
+
+void rtmidi_set_warning_callback (RtMidiPtr device, RtMidiCWarningCallback callback, void *userData)
+{
+    WarningProxyUserData* old = (WarningProxyUserData*) device->warning_data;
+    device->warning_data = (void*) new WarningProxyUserData (callback, userData);
+    ((RtMidi*) device->ptr)->setErrorCallback (warning_proxy, device->warning_data);
+    delete old;
+}
+
 void rtmidi_open_port (RtMidiPtr device, unsigned int portNumber, const char *portName)
 {
     std::string name = portName;
@@ -138,12 +169,14 @@ RtMidiInPtr rtmidi_in_create_default ()
         
         wrp->ptr = (void*) rIn;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = true;
         wrp->msg = "";
     
     } catch (const RtMidiError & err) {
         wrp->ptr = 0;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = false;
         wrp->msg = err.what ();
     }
@@ -161,12 +194,14 @@ RtMidiInPtr rtmidi_in_create (enum RtMidiApi api, const char *clientName, unsign
         
         wrp->ptr = (void*) rIn;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = true;
         wrp->msg = "";
 
     } catch (const RtMidiError & err) {
         wrp->ptr = 0;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = false;
         wrp->msg = err.what ();
     }
@@ -179,6 +214,7 @@ void rtmidi_in_free (RtMidiInPtr device)
     if (device->data)
       delete (CallbackProxyUserData*) device->data;
     delete (RtMidiIn*) device->ptr;
+    delete (WarningProxyUserData*) device->warning_data;
     delete device;
 }
 
@@ -260,6 +296,11 @@ double rtmidi_in_get_message (RtMidiInPtr device,
     }
 }
 
+double rtmidi_in_get_source_time (RtMidiInPtr device)
+{
+    return ((RtMidiIn*) device->ptr)->getSourceTime ();
+}
+
 /* RtMidiOut API */
 RtMidiOutPtr rtmidi_out_create_default ()
 {
@@ -270,12 +311,14 @@ RtMidiOutPtr rtmidi_out_create_default ()
         
         wrp->ptr = (void*) rOut;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = true;
         wrp->msg = "";
     
     } catch (const RtMidiError & err) {
         wrp->ptr = 0;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = false;
         wrp->msg = err.what ();
     }
@@ -293,12 +336,14 @@ RtMidiOutPtr rtmidi_out_create (enum RtMidiApi api, const char *clientName)
         
         wrp->ptr = (void*) rOut;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = true;
         wrp->msg = "";
     
     } catch (const RtMidiError & err) {
         wrp->ptr = 0;
         wrp->data = 0;
+        wrp->warning_data = 0;
         wrp->ok  = false;
         wrp->msg = err.what ();
     }
@@ -310,6 +355,7 @@ RtMidiOutPtr rtmidi_out_create (enum RtMidiApi api, const char *clientName)
 void rtmidi_out_free (RtMidiOutPtr device)
 {
     delete (RtMidiOut*) device->ptr;
+    delete (WarningProxyUserData*) device->warning_data;
     delete device;
 }
 
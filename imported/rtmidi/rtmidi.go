@@ -21,13 +21,24 @@ static inline void midiInCallback(double ts, const unsigned char *msg, size_t ms
 	goMIDIInCallback(ts, (unsigned char*) msg, msgsz, arg);
 }
 
-static inline void cgoSetCallback(RtMidiPtr in, int cb_id) {
-	rtmidi_in_set_callback(in, midiInCallback, (void*)(uintptr_t) cb_id);
+static inline void cgoSetCallback(RtMidiPtr in, uintptr_t handle) {
+	rtmidi_in_set_callback(in, midiInCallback, (void*) handle);
+}
+
+extern void goMIDIWarningCallback(int type, char *warning, void *arg);
+
+static inline void midiWarningCallback(enum RtMidiErrorType type, const char *warning, void *arg) {
+	goMIDIWarningCallback((int) type, (char*) warning, arg);
+}
+
+static inline void cgoSetWarningCallback(RtMidiPtr m, uintptr_t handle) {
+	rtmidi_set_warning_callback(m, midiWarningCallback, (void*) handle);
 }
 */
 import "C"
 import (
 	"errors"
+	"runtime"
 	"sync"
 	"unsafe"
 )
@@ -79,6 +90,9 @@ func CompiledAPI() (apis []API) {
 	return apis
 }
 
+// ErrDestroyed is returned when a MIDIIn or MIDIOut is used after Destroy has been called.
+var ErrDestroyed = errors.New("rtmidi: native handle already destroyed")
+
 // MIDI interface provides a common, platform-independent API for realtime MIDI
 // device enumeration and handling MIDI ports.
 type MIDI interface {
@@ -87,6 +101,7 @@ type MIDI interface {
 	Close() error
 	PortCount() (int, error)
 	PortName(port int) (string, error)
+	SetWarningHandler(func(warning string, debug bool)) error
 }
 
 // MIDIIn interface provides a common, platform-independent API for realtime
@@ -95,12 +110,19 @@ type MIDI interface {
 // method or immediately passed to a user-specified callback function. Create
 // multiple instances of this class to connect to more than one MIDI device at
 // the same time.
+//
+// Close only closes the port, the MIDIIn may be opened again. Destroy closes
+// the port and frees the native handle. It is safe to call Destroy more than
+// once, but the MIDIIn must not be used afterwards. A MIDIIn that becomes
+// unreachable without being destroyed is destroyed by a finalizer.
 type MIDIIn interface {
 	MIDI
 	API() (API, error)
 	IgnoreTypes(midiSysex bool, midiTime bool, midiSense bool) error
 	SetCallback(func(MIDIIn, []byte, float64)) error
+	SetBufferedCallback(func(MIDIIn, []byte, float64)) error
 	CancelCallback() error
+	SourceTime() (float64, bool)
 	Message() ([]byte, float64, error)
 	Destroy()
 }
@@ -110,6 +132,11 @@ type MIDIIn interface {
 // one such port, and to send MIDI bytes immediately over the connection.
 // Create multiple instances of this class to connect to more than one MIDI
 // device at the same time.
+//
+// Close only closes the port, the MIDIOut may be opened again. Destroy closes
+// the port and frees the native handle. It is safe to call Destroy more than
+// once, but the MIDIOut must not be used afterwards. A MIDIOut that becomes
+// unreachable without being destroyed is destroyed by a finalizer.
 type MIDIOut interface {
 	MIDI
 	API() (API, error)
@@ -119,9 +146,100 @@ type MIDIOut interface {
 
 type midi struct {
 	midi C.RtMidiPtr
+
+	// mx guards the native handle: calls hold the read lock, destroying holds the write lock.
+	mx        sync.RWMutex
+	destroyed bool
+
+	// warnMx guards warnHandle, the handle of the registered warning handler (0 if there is none)
+	warnMx     sync.Mutex
+	warnHandle uintptr
+}
+
+// free frees the native handle exactly once. It reports whether it did.
+// The write lock only waits for the running calls and marks the handle as destroyed; the native handle
+// is freed outside of it, since freeing an in port joins the input thread, and a callback running
+// at that moment may call methods that take the lock (they fail with ErrDestroyed then).
+func (m *midi) free(freeFn func()) bool {
+	m.mx.Lock()
+	if m.destroyed {
+		m.mx.Unlock()
+		return false
+	}
+	m.destroyed = true
+	m.mx.Unlock()
+
+	freeFn()
+
+	m.warnMx.Lock()
+	if m.warnHandle != 0 {
+		unregisterWarningHandler(m.warnHandle)
+		m.warnHandle = 0
+	}
+	m.warnMx.Unlock()
+	return true
+}
+
+// Warning handlers are registered like callbacks under handles that are never reused.
+var (
+	warningHandlersMx sync.RWMutex
+	warningHandlers   = map[uintptr]func(string, bool){}
+	lastWarningHandle uintptr
+)
+
+func registerWarningHandler(fn func(string, bool)) uintptr {
+	warningHandlersMx.Lock()
+	defer warningHandlersMx.Unlock()
+	lastWarningHandle++
+	warningHandlers[lastWarningHandle] = fn
+	return lastWarningHandle
+}
+
+func unregisterWarningHandler(handle uintptr) {
+	warningHandlersMx.Lock()
+	defer warningHandlersMx.Unlock()
+	delete(warningHandlers, handle)
+}
+
+//export goMIDIWarningCallback
+func goMIDIWarningCallback(typ C.int, warning *C.char, arg unsafe.Pointer) {
+	warningHandlersMx.RLock()
+	fn := warningHandlers[uintptr(arg)]
+	warningHandlersMx.RUnlock()
+	if fn != nil {
+		fn(C.GoString(warning), typ == C.RT_ERROR_DEBUG_WARNING)
+	}
+}
+
+// SetWarningHandler sets a function that gets the warnings of the native API instead of
+// printing them to stderr. debug is true for warnings that are only useful for debugging.
+// The function may be called on the thread of the native API. It replaces a handler that
+// has been set before.
+func (m *midi) SetWarningHandler(fn func(warning string, debug bool)) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
+
+	m.warnMx.Lock()
+	defer m.warnMx.Unlock()
+
+	old := m.warnHandle
+	m.warnHandle = registerWarningHandler(fn)
+	C.cgoSetWarningCallback(m.midi, C.uintptr_t(m.warnHandle))
+	if old != 0 {
+		unregisterWarningHandler(old)
+	}
+	return nil
 }
 
 func (m *midi) OpenPort(port int, name string) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
 	p := C.CString(name)
 	defer C.free(unsafe.Pointer(p))
 	C.rtmidi_open_port(m.midi, C.uint(port), p)
@@ -132,6 +250,11 @@ func (m *midi) OpenPort(port int, name string) error {
 }
 
 func (m *midi) OpenVirtualPort(name string) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
 	p := C.CString(name)
 	defer C.free(unsafe.Pointer(p))
 	C.rtmidi_open_virtual_port(m.midi, p)
@@ -142,6 +265,11 @@ func (m *midi) OpenVirtualPort(name string) error {
 }
 
 func (m *midi) PortName(port int) (string, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return "", ErrDestroyed
+	}
 	p := C.rtmidi_get_port_name(m.midi, C.uint(port))
 	if !m.midi.ok {
 		return "", errors.New(C.GoString(m.midi.msg))
@@ -151,6 +279,11 @@ func (m *midi) PortName(port int) (string, error) {
 }
 
 func (m *midi) PortCount() (int, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return 0, ErrDestroyed
+	}
 	n := C.rtmidi_get_port_count(m.midi)
 	if !m.midi.ok {
 		return 0, errors.New(C.GoString(m.midi.msg))
@@ -159,6 +292,11 @@ func (m *midi) PortCount() (int, error) {
 }
 
 func (m *midi) Close() error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
 	C.rtmidi_close_port(C.RtMidiPtr(m.midi))
 	if !m.midi.ok {
 		return errors.New(C.GoString(m.midi.msg))
@@ -169,7 +307,10 @@ func (m *midi) Close() error {
 type midiIn struct {
 	midi
 	in C.RtMidiInPtr
-	cb func(MIDIIn, []byte, float64)
+
+	// cbMx guards cbHandle, the handle of the registered callback (0 if there is none)
+	cbMx     sync.Mutex
+	cbHandle uintptr
 }
 
 type midiOut struct {
@@ -184,7 +325,13 @@ func NewMIDIInDefault() (MIDIIn, error) {
 		defer C.rtmidi_in_free(in)
 		return nil, errors.New(C.GoString(in.msg))
 	}
-	return &midiIn{in: in, midi: midi{midi: C.RtMidiPtr(in)}}, nil
+	return newMIDIIn(in), nil
+}
+
+func newMIDIIn(in C.RtMidiInPtr) *midiIn {
+	m := &midiIn{in: in, midi: midi{midi: C.RtMidiPtr(in)}}
+	runtime.SetFinalizer(m, (*midiIn).Destroy)
+	return m
 }
 
 // NewMIDIIn opens a single MIDIIn port using the given API. One can provide a
@@ -197,10 +344,15 @@ func NewMIDIIn(api API, name string, queueSize int) (MIDIIn, error) {
 		defer C.rtmidi_in_free(in)
 		return nil, errors.New(C.GoString(in.msg))
 	}
-	return &midiIn{in: in, midi: midi{midi: C.RtMidiPtr(in)}}, nil
+	return newMIDIIn(in), nil
 }
 
 func (m *midiIn) API() (API, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return APIUnspecified, ErrDestroyed
+	}
 	api := C.rtmidi_in_get_current_api(m.in)
 	if !m.in.ok {
 		return APIUnspecified, errors.New(C.GoString(m.in.msg))
@@ -209,15 +361,18 @@ func (m *midiIn) API() (API, error) {
 }
 
 func (m *midiIn) Close() error {
-	unregisterMIDIIn(m)
-	if err := m.midi.Close(); err != nil {
+	if err := m.CancelCallback(); err != nil {
 		return err
 	}
-	C.rtmidi_in_free(m.in)
-	return nil
+	return m.midi.Close()
 }
 
 func (m *midiIn) IgnoreTypes(midiSysex bool, midiTime bool, midiSense bool) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
 	C.rtmidi_in_ignore_types(m.in, C._Bool(midiSysex), C._Bool(midiTime), C._Bool(midiSense))
 	if !m.in.ok {
 		return errors.New(C.GoString(m.in.msg))
@@ -225,58 +380,136 @@ func (m *midiIn) IgnoreTypes(midiSysex bool, midiTime bool, midiSense bool) erro
 	return nil
 }
 
+// Callbacks are registered under handles that are passed to the native side as user data.
+// A handle is never reused, so it also identifies the generation of the callback of an input:
+// calls that arrive late for a handle that has been canceled, closed or destroyed find no
+// registered callback and are discarded.
 var (
-	mu     sync.Mutex
-	inputs = map[int]*midiIn{}
+	callbacksMx        sync.RWMutex
+	callbacks          = map[uintptr]*callback{}
+	lastCallbackHandle uintptr
 )
 
-func registerMIDIIn(m *midiIn) int {
-	mu.Lock()
-	defer mu.Unlock()
-	for i := 0; ; i++ {
-		if _, ok := inputs[i]; !ok {
-			inputs[i] = m
-			return i
-		}
-	}
+type callback struct {
+	in *midiIn
+	fn func(MIDIIn, []byte, float64)
+
+	// buffered callbacks get the messages in buf, which is reused.
+	// it is only touched by the native input thread.
+	buffered bool
+	buf      []byte
 }
 
-func unregisterMIDIIn(m *midiIn) {
-	mu.Lock()
-	defer mu.Unlock()
-	for i := 0; i < len(inputs); i++ {
-		if inputs[i] == m {
-			delete(inputs, i)
-			return
-		}
-	}
+func registerCallback(m *midiIn, fn func(MIDIIn, []byte, float64), buffered bool) uintptr {
+	callbacksMx.Lock()
+	defer callbacksMx.Unlock()
+	lastCallbackHandle++
+	callbacks[lastCallbackHandle] = &callback{in: m, fn: fn, buffered: buffered}
+	return lastCallbackHandle
+}
+
+func unregisterCallback(handle uintptr) {
+	callbacksMx.Lock()
+	defer callbacksMx.Unlock()
+	delete(callbacks, handle)
 }
 
-func findMIDIIn(k int) *midiIn {
-	mu.Lock()
-	defer mu.Unlock()
-	return inputs[k]
+func findCallback(handle uintptr) *callback {
+	callbacksMx.RLock()
+	defer callbacksMx.RUnlock()
+	return callbacks[handle]
 }
 
 //export goMIDIInCallback
 func goMIDIInCallback(ts C.double, msg *C.uchar, msgsz C.size_t, arg unsafe.Pointer) {
-	k := int(uintptr(arg))
-	m := findMIDIIn(k)
-	m.cb(m, C.GoBytes(unsafe.Pointer(msg), C.int(msgsz)), float64(ts))
+	dispatchCallback(uintptr(arg), unsafe.Pointer(msg), int(msgsz), float64(ts))
 }
 
+// maxMessageSize is the largest message a buffered callback can get at once.
+const maxMessageSize = 1 << 30
+
+func dispatchCallback(handle uintptr, msg unsafe.Pointer, msgsz int, ts float64) {
+	cb := findCallback(handle)
+	if cb == nil {
+		return
+	}
+
+	if !cb.buffered {
+		cb.fn(cb.in, C.GoBytes(msg, C.int(msgsz)), ts)
+		return
+	}
+
+	if cap(cb.buf) < msgsz {
+		cb.buf = make([]byte, msgsz)
+	}
+	cb.buf = cb.buf[:msgsz]
+	if msgsz > 0 {
+		copy(cb.buf, (*[maxMessageSize]byte)(msg)[:msgsz:msgsz])
+	}
+	cb.fn(cb.in, cb.buf, ts)
+}
+
+// SetCallback sets the function that is called for every incoming message.
+// It replaces a callback that has been set before.
+// The callback is called on the thread of the native API and must not call
+// Destroy of the same MIDIIn.
 func (m *midiIn) SetCallback(cb func(MIDIIn, []byte, float64)) error {
-	k := registerMIDIIn(m)
-	m.cb = cb
-	C.cgoSetCallback(m.in, C.int(k))
+	return m.setCallback(cb, false)
+}
+
+// SetBufferedCallback is like SetCallback, but the message is passed in a buffer
+// that is reused for the next message of the MIDIIn, so that no memory is allocated per message.
+// The message is only valid until the callback returns; it must not be modified or retained
+// and has to be copied if it is needed afterwards.
+func (m *midiIn) SetBufferedCallback(cb func(MIDIIn, []byte, float64)) error {
+	return m.setCallback(cb, true)
+}
+
+func (m *midiIn) setCallback(cb func(MIDIIn, []byte, float64), buffered bool) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
+
+	m.cbMx.Lock()
+	defer m.cbMx.Unlock()
+
+	if err := m.cancelCallback(); err != nil {
+		return err
+	}
+
+	h := registerCallback(m, cb, buffered)
+	C.cgoSetCallback(m.in, C.uintptr_t(h))
 	if !m.in.ok {
+		unregisterCallback(h)
 		return errors.New(C.GoString(m.in.msg))
 	}
+	m.cbHandle = h
 	return nil
 }
 
+// CancelCallback removes the callback. Calls that are late are discarded.
+// It is not an error if there is no callback.
 func (m *midiIn) CancelCallback() error {
-	unregisterMIDIIn(m)
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
+
+	m.cbMx.Lock()
+	defer m.cbMx.Unlock()
+	return m.cancelCallback()
+}
+
+// cancelCallback must be called with the read lock of mx and the lock of cbMx held.
+func (m *midiIn) cancelCallback() error {
+	if m.cbHandle == 0 {
+		return nil
+	}
+	unregisterCallback(m.cbHandle)
+	m.cbHandle = 0
 	C.rtmidi_in_cancel_callback(m.in)
 	if !m.in.ok {
 		return errors.New(C.GoString(m.in.msg))
@@ -284,7 +517,25 @@ func (m *midiIn) CancelCallback() error {
 	return nil
 }
 
+// SourceTime returns the time stamp in seconds that the native API has given to the message that is
+// currently passed to the callback, e.g. the real-time of the ALSA sequencer queue.
+// ok is false, if the API does not provide such time stamps.
+// SourceTime must only be called from within the callback.
+func (m *midiIn) SourceTime() (seconds float64, ok bool) {
+	// no locking: the callback runs on the input thread, which is stopped before the handle is freed
+	t := float64(C.rtmidi_in_get_source_time(m.in))
+	if t < 0 {
+		return 0, false
+	}
+	return t, true
+}
+
 func (m *midiIn) Message() ([]byte, float64, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return nil, 0, ErrDestroyed
+	}
 	msg := make([]C.uchar, 64*1024, 64*1024)
 	sz := C.size_t(len(msg))
 	r := C.rtmidi_in_get_message(m.in, &msg[0], &sz)
@@ -299,7 +550,16 @@ func (m *midiIn) Message() ([]byte, float64, error) {
 }
 
 func (m *midiIn) Destroy() {
-	C.rtmidi_in_free(m.in)
+	// freeing stops the input thread, so no callback can arrive after unregistering
+	if m.free(func() { C.rtmidi_in_free(m.in) }) {
+		runtime.SetFinalizer(m, nil)
+	}
+	m.cbMx.Lock()
+	if m.cbHandle != 0 {
+		unregisterCallback(m.cbHandle)
+		m.cbHandle = 0
+	}
+	m.cbMx.Unlock()
 }
 
 // NewMIDIOutDefault opens a default MIDIOut port.
@@ -309,7 +569,13 @@ func NewMIDIOutDefault() (MIDIOut, error) {
 		defer C.rtmidi_out_free(out)
 		return nil, errors.New(C.GoString(out.msg))
 	}
-	return &midiOut{out: out, midi: midi{midi: C.RtMidiPtr(out)}}, nil
+	return newMIDIOut(out), nil
+}
+
+func newMIDIOut(out C.RtMidiOutPtr) *midiOut {
+	m := &midiOut{out: out, midi: midi{midi: C.RtMidiPtr(out)}}
+	runtime.SetFinalizer(m, (*midiOut).Destroy)
+	return m
 }
 
 // NewMIDIOut opens a single MIDIIn port using the given API with the given port name.
@@ -321,10 +587,15 @@ func NewMIDIOut(api API, name string) (MIDIOut, error) {
 		defer C.rtmidi_out_free(out)
 		return nil, errors.New(C.GoString(out.msg))
 	}
-	return &midiOut{out: out, midi: midi{midi: C.RtMidiPtr(out)}}, nil
+	return newMIDIOut(out), nil
 }
 
 func (m *midiOut) API() (API, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return APIUnspecified, ErrDestroyed
+	}
 	api := C.rtmidi_out_get_current_api(m.out)
 	if !m.out.ok {
 		return APIUnspecified, errors.New(C.GoString(m.out.msg))
@@ -333,14 +604,15 @@ func (m *midiOut) API() (API, error) {
 }
 
 func (m *midiOut) Close() error {
-	if err := m.midi.Close(); err != nil {
-		return err
-	}
-	C.rtmidi_out_free(m.out)
-	return nil
+	return m.midi.Close()
 }
 
 func (m *midiOut) SendMessage(b []byte) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if m.destroyed {
+		return ErrDestroyed
+	}
 	p := C.CBytes(b)
 	defer C.free(unsafe.Pointer(p))
 	C.rtmidi_out_send_message(m.out, (*C.uchar)(p), C.int(len(b)))
@@ -351,5 +623,7 @@ func (m *midiOut) SendMessage(b []byte) error {
 }
 
 func (m *midiOut) Destroy() {
-	C.rtmidi_out_free(m.out)
+	if m.free(func() { C.rtmidi_out_free(m.out) }) {
+		runtime.SetFinalizer(m, nil)
+	}
 }
@@ -19,6 +19,7 @@ struct RtMidiWrapper {
     //! The wrapped RtMidi object.
     void* ptr;
     void* data;
+    void* warning_data;
 
     //! True when the last function call was OK. 
     bool  ok;
@@ -60,6 +61,14 @@ enum RtMidiErrorType {
 typedef void(* RtMidiCCallback) (double timeStamp, const unsigned char* message,
                                  size_t messageSize, void *userData);
 
+/*! The type of a RtMidi warning callback function.
+ * \param type        RT_ERROR_WARNING or RT_ERROR_DEBUG_WARNING.
+ * \param warning     The text of the warning.
+ * \param userData    Additional user data for the callback.
+ */
+typedef void(* RtMidiCWarningCallback) (enum RtMidiErrorType type, const char* warning,
+                                        void *userData);
+
 
 /* RtMidi API */
 
@@ -78,6 +87,11 @@ RTMIDIAPI int rtmidi_get_compiled_api (enum RtMidiApi *apis, unsigned int apis_s
 //! Report an error.
 RTMIDIAPI void rtmidi_error (enum RtMidiErrorType type, const char* errorString);
 
+/*! Pass the warnings of the device to the callback instead of printing them.
+ * Errors are still reported by the return status.
+ */
+RTMIDIAPI void rtmidi_set_warning_callback (RtMidiPtr device, RtMidiCWarningCallback callback, void *userData);
+
 /*! Open a MIDI port.  
  *
  * \param port      Must be greater than 0
@@ -146,6 +160,12 @@ RTMIDIAPI void rtmidi_in_ignore_types (RtMidiInPtr device, bool midiSysex, bool
  */
 RTMIDIAPI double rtmidi_in_get_message (RtMidiInPtr device, unsigned char *message, size_t *size);
 
+/*! Return the time stamp of the API in seconds for the message that is currently
+ * passed to the callback, or a negative value if the API does not provide it.
+ * Must only be called from within the callback.
+ */
+RTMIDIAPI double rtmidi_in_get_source_time (RtMidiInPtr device);
+
 /* RtMidiOut API */
 
 //! Create a default RtMidiInPtr value, with no initialization.
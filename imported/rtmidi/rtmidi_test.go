@@ -2,6 +2,9 @@ package rtmidi
 
 import (
 	"log"
+	"testing"
+	"time"
+	"unsafe"
 )
 
 func ExampleCompiledAPI() {
@@ -44,3 +47,113 @@ func ExampleMIDIIn_SetCallback() {
 	})
 	<-make(chan struct{})
 }
+
+func benchmarkCallback(b *testing.B, buffered bool) {
+	// aftertouch, as streamed by many controllers
+	msg := []byte{0xA0, 60, 100}
+	var sum int
+	h := registerCallback(&midiIn{}, func(_ MIDIIn, bt []byte, _ float64) {
+		sum += int(bt[2])
+	}, buffered)
+	defer unregisterCallback(h)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dispatchCallback(h, unsafe.Pointer(&msg[0]), len(msg), 0.001)
+	}
+}
+
+func BenchmarkCallback(b *testing.B) {
+	benchmarkCallback(b, false)
+}
+
+func BenchmarkBufferedCallback(b *testing.B) {
+	benchmarkCallback(b, true)
+}
+
+func TestFree(t *testing.T) {
+	m := &midi{}
+
+	freed := make(chan bool)
+	go func() {
+		freed <- m.free(func() {
+			// freeing an in port joins the input thread: the callback running there takes the lock
+			cb := make(chan bool)
+			go func() {
+				m.mx.RLock()
+				cb <- m.destroyed
+				m.mx.RUnlock()
+			}()
+			if !<-cb {
+				t.Errorf("handle not marked as destroyed while freeing")
+			}
+		})
+	}()
+
+	select {
+	case ok := <-freed:
+		if !ok {
+			t.Errorf("handle not freed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deadlock while freeing")
+	}
+
+	if m.free(func() { t.Errorf("freed twice") }) {
+		t.Errorf("second free reported freeing")
+	}
+}
+
+func TestCallbackHandles(t *testing.T) {
+	msg := []byte{0x90, 60, 100}
+	var got [][]byte
+	record := func(_ MIDIIn, bt []byte, _ float64) {
+		got = append(got, bt)
+	}
+
+	old := registerCallback(&midiIn{}, record, false)
+	unregisterCallback(old)
+
+	h := registerCallback(&midiIn{}, record, false)
+	defer unregisterCallback(h)
+	if h == old {
+		t.Fatalf("handle %v reused", h)
+	}
+
+	// a late call for the unregistered handle is discarded
+	dispatchCallback(old, unsafe.Pointer(&msg[0]), len(msg), 0)
+	if len(got) != 0 {
+		t.Fatalf("late call dispatched: %v", got)
+	}
+
+	dispatchCallback(h, unsafe.Pointer(&msg[0]), len(msg), 0)
+	if len(got) != 1 || string(got[0]) != string(msg) {
+		t.Fatalf("got %v", got)
+	}
+
+	// unbuffered callbacks get their own copy
+	msg[2] = 0
+	if got[0][2] != 100 {
+		t.Errorf("message not copied")
+	}
+}
+
+func TestBufferedCallback(t *testing.T) {
+	var got [][]byte
+	h := registerCallback(&midiIn{}, func(_ MIDIIn, bt []byte, _ float64) {
+		got = append(got, bt)
+	}, true)
+	defer unregisterCallback(h)
+
+	for _, msg := range [][]byte{{0x90, 60, 100}, {0x80, 60, 0}} {
+		dispatchCallback(h, unsafe.Pointer(&msg[0]), len(msg), 0)
+	}
+
+	if len(got) != 2 || &got[0][0] != &got[1][0] {
+		t.Fatalf("buffer not reused")
+	}
+	if string(got[1]) != string([]byte{0x80, 60, 0}) {
+		t.Errorf("got % X", got[1])
+	}
+}
@@ -1,30 +1,32 @@
 package rtmididrv
 
 import (
+	"context"
 	"fmt"
-	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gomidi/connect"
-	"github.com/minikomi/rtmididrv/imported/rtmidi"
 	//	"github.com/metakeule/mutex"
 )
 
+// in is a handle to a MIDI in port. All open handles of the same port share one native connection.
 type in struct {
-	driver *driver
+	driver *Driver
 	number int
 	name   string
-	midiIn rtmidi.MIDIIn
+	conn   *inConn
 	sync.RWMutex
 	//	mutex.RWMutex
+	open        bool
 	listenerSet bool
-	closed      bool
 }
 
 // IsOpen returns wether the MIDI in port is open
 func (i *in) IsOpen() (open bool) {
 	i.RLock()
-	open = !i.closed && i.midiIn != nil
+	open = i.open
 	i.RUnlock()
 	return
 }
@@ -36,8 +38,22 @@ func (i *in) String() string {
 
 // Underlying returns the underlying rtmidi.MIDIIn. Use it with type casting:
 //   rtIn := i.Underlying().(rtmidi.MIDIIn)
+// The rtmidi.MIDIIn is shared by all open handles of the port.
 func (i *in) Underlying() interface{} {
-	return i.midiIn
+	c := i.connection()
+	c.Lock()
+	defer c.Unlock()
+	if c.midiIn == nil {
+		return nil
+	}
+	return c.midiIn
+}
+
+// connection returns the connection of the handle. It is replaced whenever the handle is opened.
+func (i *in) connection() *inConn {
+	i.RLock()
+	defer i.RUnlock()
+	return i.conn
 }
 
 // Number returns the number of the MIDI in port.
@@ -47,119 +63,184 @@ func (i *in) Number() int {
 	return i.number
 }
 
+// Handle returns a new handle to the same MIDI in port. The new handle is not open.
+// Every handle is opened and closed on its own, while the native port is shared by all open handles
+// and released when the last of them is closed.
+func (i *in) Handle() In {
+	return newIn(i.driver.debug, i.driver, i.number, i.name)
+}
+
+// Dropped returns the number of incoming messages that have been dropped, because the queue of the port was full.
+// The number is shared by all handles of the port.
+func (i *in) Dropped() uint64 {
+	return atomic.LoadUint64(&i.connection().stats.dropped)
+}
+
+// SlowListeners returns the number of listener calls that exceeded the listener budget.
+// The number is shared by all handles of the port.
+func (i *in) SlowListeners() uint64 {
+	return atomic.LoadUint64(&i.connection().stats.slowListeners)
+}
+
+// Stats returns the traffic counters of the port. They are shared by all handles of the port.
+func (i *in) Stats() Stats {
+	c := i.connection()
+	return c.stats.snapshot("in", c.key)
+}
+
 // Close closes the MIDI in port, after it has stopped listening.
 func (i *in) Close() error {
-	i.RLock()
-	if i.closed || i.midiIn == nil {
-		i.RUnlock()
-		return nil
-	}
-	i.RUnlock()
+	return i.CloseContext(context.Background())
+}
 
+// CloseContext closes the MIDI in port, after it has stopped listening.
+// If ctx is done before the port could be closed, an error wrapping ctx.Err() is returned.
+// The port counts as closed anyway and the native port is released in the background.
+// A closed port may be opened again.
+func (i *in) CloseContext(ctx context.Context) error {
 	i.Lock()
-	i.closed = true
-	i.stopListening()
+	if !i.open {
+		i.Unlock()
+		return nil
+	}
+	i.open = false
+	if i.listenerSet {
+		// the native callback is canceled anyway, when the last handle is released
+		i.conn.removeListener(i)
+		i.listenerSet = false
+	}
+	c := i.conn
 	i.Unlock()
 
-	//time.Sleep(time.Millisecond * 500)
-	//i.Lock()
-	err := i.midiIn.Close()
-	//i.Unlock()
+	i.driver.unregister(i)
+
+	err := c.release(ctx)
+	i.driver.pruneInConn(c)
 	if err != nil {
-		return fmt.Errorf("can't close MIDI in port %v (%s): %v", i.number, i, err)
+		return fmt.Errorf("can't close MIDI in port %v (%s): %w", i.number, i, err)
 	}
 
 	return nil
 }
 
 // Open opens the MIDI in port
-func (i *in) Open() (err error) {
-	i.RLock()
-	if i.closed || i.midiIn != nil {
-		i.RUnlock()
-		return nil
-	}
-	i.RUnlock()
+func (i *in) Open() error {
+	return i.OpenContext(context.Background())
+}
 
+// OpenContext opens the MIDI in port. If ctx is done before the port could be opened,
+// ctx.Err() is returned and the port is closed again as soon as the pending native call returns.
+func (i *in) OpenContext(ctx context.Context) (err error) {
 	i.Lock()
 	defer i.Unlock()
 
-	i.midiIn, err = rtmidi.NewMIDIInDefault()
-	if err != nil {
-		i.midiIn = nil
-		return fmt.Errorf("can't open default MIDI in: %v", err)
+	if i.open {
+		return nil
 	}
 
-	err = i.midiIn.OpenPort(i.number, "")
+	c, err := i.driver.acquireInConn(ctx, i.number, i.name)
 	if err != nil {
-		//i.midiIn.Destroy()
-		i.midiIn = nil
-		return fmt.Errorf("can't open MIDI in port %v (%s): %v", i.number, i, err)
+		return err
 	}
 
-	i.driver.Lock()
-	i.driver.opened = append(i.driver.opened, i)
-	i.driver.Unlock()
+	i.conn = c
+	i.open = true
+	i.driver.register(i)
 
 	return nil
 }
 
-func newIn(debug bool, driver *driver, number int, name string) connect.In {
+func newIn(debug bool, driver *Driver, number int, name string) In {
 	i := &in{driver: driver, number: number, name: name}
+	// the handle gets the shared connection when it is opened
+	i.conn = &inConn{driver: driver, key: portKey{number, name}}
 	//	i.RWMutex = mutex.NewRWMutex("rtmididrv in port "+name, debug)
 	return i
 }
 
+// ListenerPanic is reported to the error handler of the driver, when a listener panicked.
+// The listener keeps listening.
+type ListenerPanic struct {
+	// Port is the name of the MIDI in port
+	Port string
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+// Error returns the error message.
+func (l *ListenerPanic) Error() string {
+	return fmt.Sprintf("listener of MIDI in port %s panicked: %v\n%s", l.Port, l.Value, l.Stack)
+}
+
+// SlowListener is reported to the error handler of the driver, when a listener exceeded the listener budget.
+type SlowListener struct {
+	// Port is the name of the MIDI in port
+	Port string
+	// Took is the time that the listener took
+	Took time.Duration
+	// Budget is the listener budget
+	Budget time.Duration
+}
+
+// Error returns the error message.
+func (s *SlowListener) Error() string {
+	return fmt.Sprintf("listener of MIDI in port %s took %v (budget %v)", s.Port, s.Took, s.Budget)
+}
+
 // SetListener makes the listener listen to the in port
-func (i *in) SetListener(listener func(data []byte, deltaMicroseconds int64)) (err error) {
-	i.RLock()
-	if i.closed || i.midiIn == nil {
-		i.RUnlock()
+// deltaMicroseconds is the distance to the previous message of the port. For the first message,
+// it is the time since the port started listening.
+// If the driver has been created with ReuseInputBuffers, data is only valid until the listener returns.
+// Panics of the listener are recovered and reported to the error handler of the driver.
+func (i *in) SetListener(listener func(data []byte, deltaMicroseconds int64)) error {
+	return i.setListener(func(data []byte, _, deltaMicroseconds int64) {
+		listener(data, deltaMicroseconds)
+	})
+}
+
+// SetTimestampedListener is like SetListener, but the listener also gets the timestamp of the message
+// in microseconds. The timestamps of all ports of a driver share the time base of Driver.Now and never go backwards.
+// How they are measured depends on the TimestampSource of the driver.
+func (i *in) SetTimestampedListener(listener func(data []byte, timestampMicroseconds, deltaMicroseconds int64)) error {
+	return i.setListener(listener)
+}
+
+func (i *in) setListener(listener func(data []byte, timestampMicroseconds, deltaMicroseconds int64)) (err error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.open {
 		return connect.ErrClosed
 	}
 
 	if i.listenerSet {
-		i.RUnlock()
 		return fmt.Errorf("listener allread set")
 	}
-	i.RUnlock()
-	i.Lock()
-	i.listenerSet = true
-	i.Unlock()
 
-	// since i.midiIn.SetCallback is blocking on success, there is no meaningful way to get an error
-	// and set the callback non blocking
-	go i.midiIn.SetCallback(func(_ rtmidi.MIDIIn, bt []byte, deltaSeconds float64) {
-		// we want deltaMicroseconds as int64
-		listener(bt, int64(math.Round(deltaSeconds*1000000)))
-	})
+	err = i.conn.addListener(i, listener)
+	if err != nil {
+		return fmt.Errorf("can't set listener for MIDI in port %v (%s): %v", i.number, i, err)
+	}
 
-	/*
-		if err != nil {
-			fmt.Errorf("can't set listener for MIDI in port %v (%s): %v", i.number, i, err)
-		}
-	*/
+	i.listenerSet = true
 	return nil
 }
 
 // StopListening cancels the listening
 func (i *in) StopListening() error {
-	i.RLock()
-	if i.closed || i.midiIn == nil {
-		i.RUnlock()
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.open {
 		return connect.ErrClosed
 	}
-	i.RUnlock()
-	i.Lock()
-	err := i.stopListening()
-	i.Unlock()
-	return err
-}
 
-func (i *in) stopListening() error {
-	err := i.midiIn.CancelCallback()
+	err := i.conn.removeListener(i)
 	if err != nil {
-		fmt.Errorf("can't stop listening on MIDI in port %v (%s): %v", i.number, i, err)
+		return fmt.Errorf("can't stop listening on MIDI in port %v (%s): %v", i.number, i, err)
 	}
+	i.listenerSet = false
 	return nil
 }
@@ -0,0 +1,238 @@
+package rtmididrv
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minikomi/rtmididrv/imported/rtmidi"
+	"github.com/minikomi/rtmididrv/miditext"
+)
+
+// LogLevel is the importance of a log record. The levels have the same values as those of log/slog.
+type LogLevel int
+
+const (
+	// LogDebug is the level of the messages that are sent and received and of debug warnings of the backend.
+	LogDebug LogLevel = -4
+	// LogInfo is the level of port lifecycle events.
+	LogInfo LogLevel = 0
+	// LogWarn is the level of the warnings of the backend.
+	LogWarn LogLevel = 4
+	// LogError is the level of the errors that are passed to the error handler.
+	LogError LogLevel = 8
+)
+
+// String returns the name of the level.
+func (l LogLevel) String() string {
+	switch {
+	case l < LogInfo:
+		return "DEBUG"
+	case l < LogWarn:
+		return "INFO"
+	case l < LogError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// LogAttr is a key value pair of a log record.
+type LogAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// LogRecord is an event that is logged.
+type LogRecord struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Attrs   []LogAttr
+}
+
+// LogHandler handles the log records of the driver, like a slog.Handler.
+// An adapter to log/slog only has to convert the record.
+type LogHandler interface {
+	// Enabled reports whether records of the given level are handled. It is called before
+	// a record is built, so that disabled levels cost next to nothing.
+	Enabled(level LogLevel) bool
+
+	// Handle handles the record. It may be called concurrently, also from the native input threads,
+	// and should return quickly.
+	Handle(r LogRecord) error
+}
+
+// NewTextLogHandler returns a LogHandler that writes the records of the given level and above
+// to w, one line per record, in logfmt.
+func NewTextLogHandler(w io.Writer, level LogLevel) LogHandler {
+	return &textLogHandler{w: w, level: level}
+}
+
+type textLogHandler struct {
+	level LogLevel
+	mx    sync.Mutex
+	w     io.Writer
+}
+
+func (h *textLogHandler) Enabled(level LogLevel) bool {
+	return level >= h.level
+}
+
+func (h *textLogHandler) Handle(r LogRecord) error {
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(r.Time.Format(time.RFC3339Nano))
+	b.WriteString(" level=")
+	b.WriteString(r.Level.String())
+	b.WriteString(" msg=")
+	b.WriteString(logValue(r.Message))
+	for _, a := range r.Attrs {
+		b.WriteByte(' ')
+		b.WriteString(a.Key)
+		b.WriteByte('=')
+		b.WriteString(logValue(fmt.Sprint(a.Value)))
+	}
+	b.WriteByte('\n')
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// logValue quotes s, if it is empty or contains spaces, quotes or equal signs.
+func logValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Logger sets the handler for the log records of the driver: the opening and closing of ports (LogInfo),
+// every message that is sent or received (LogDebug), the warnings of the backend (LogWarn) and the errors
+// that are passed to the error handler (LogError).
+// Without a logger the driver does not log and the backend writes its warnings to stderr.
+func Logger(handler LogHandler) Option {
+	return func(d *Driver) {
+		d.logger = handler
+		d.debug = handler != nil
+	}
+}
+
+// LogSampling lets the logger only get every n-th message of each port and direction.
+// Lifecycle events, warnings and errors are not sampled.
+func LogSampling(n int) Option {
+	return func(d *Driver) {
+		d.logSampling = uint64(n)
+	}
+}
+
+// LogRateLimit lets the logger get at most perSecond messages per second for the whole driver.
+// The number of messages that have been left out is attached to the next logged message as "suppressed".
+// Lifecycle events, warnings and errors are not limited.
+func LogRateLimit(perSecond int) Option {
+	return func(d *Driver) {
+		d.logLimiter.perSecond = perSecond
+	}
+}
+
+// logRateLimiter limits the logged messages per second in windows of one second.
+type logRateLimiter struct {
+	perSecond int
+
+	sync.Mutex
+	window     time.Time
+	n          int
+	suppressed uint64
+}
+
+// allow reports whether a message may be logged now and how many messages have been suppressed before.
+func (r *logRateLimiter) allow(now time.Time) (ok bool, suppressed uint64) {
+	if r.perSecond <= 0 {
+		return true, 0
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if now.Sub(r.window) >= time.Second {
+		r.window = now
+		r.n = 0
+	}
+
+	if r.n >= r.perSecond {
+		r.suppressed++
+		return false, 0
+	}
+
+	r.n++
+	suppressed = r.suppressed
+	r.suppressed = 0
+	return true, suppressed
+}
+
+// log passes a record to the logger, if there is one and the level is enabled.
+func (d *Driver) log(level LogLevel, msg string, attrs ...LogAttr) {
+	if d.logger == nil || !d.logger.Enabled(level) {
+		return
+	}
+	d.logger.Handle(LogRecord{Time: time.Now(), Level: level, Message: msg, Attrs: attrs})
+}
+
+// logPort logs a lifecycle event of a port.
+func (d *Driver) logPort(msg string, direction string, key portKey) {
+	d.log(LogInfo, msg, LogAttr{"direction", direction}, LogAttr{"number", key.number}, LogAttr{"port", key.name})
+}
+
+// logMessage logs a message that has been sent or received, subject to sampling and rate limit.
+// count is the counter of the port that is used for sampling. A negative delta is left out.
+func (d *Driver) logMessage(msg string, key portKey, count *uint64, data []byte, timestampMicroseconds, deltaMicroseconds int64) {
+	if d.logger == nil || !d.logger.Enabled(LogDebug) {
+		return
+	}
+
+	if n := atomic.AddUint64(count, 1); d.logSampling > 1 && (n-1)%d.logSampling != 0 {
+		return
+	}
+
+	now := time.Now()
+	ok, suppressed := d.logLimiter.allow(now)
+	if !ok {
+		return
+	}
+
+	attrs := []LogAttr{
+		{"port", key.name},
+		{"number", key.number},
+		{"timestamp", timestampMicroseconds},
+	}
+	if deltaMicroseconds >= 0 {
+		attrs = append(attrs, LogAttr{"delta", deltaMicroseconds})
+	}
+	attrs = append(attrs, LogAttr{"message", miditext.Format(data)})
+	if suppressed > 0 {
+		attrs = append(attrs, LogAttr{"suppressed", suppressed})
+	}
+
+	d.logger.Handle(LogRecord{Time: now, Level: LogDebug, Message: msg, Attrs: attrs})
+}
+
+// logWarnings lets the logger get the warnings of the backend for m.
+func (d *Driver) logWarnings(m rtmidi.MIDI, direction string, key portKey) {
+	if d.logger == nil {
+		return
+	}
+	m.SetWarningHandler(func(warning string, debug bool) {
+		level := LogWarn
+		if debug {
+			level = LogDebug
+		}
+		d.log(level, "backend warning", LogAttr{"direction", direction}, LogAttr{"number", key.number},
+			LogAttr{"port", key.name}, LogAttr{"warning", warning})
+	})
+}
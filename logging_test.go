@@ -0,0 +1,44 @@
+package rtmididrv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogMessages(t *testing.T) {
+	var buf bytes.Buffer
+	d, _ := New(Logger(NewTextLogHandler(&buf, LogDebug)), LogSampling(2))
+	c := d.inConn(0, "Keyboard 1")
+
+	for i := 0; i < 4; i++ {
+		c.dispatch([]byte{0x90, 60, 100}, int64(i*1000), 1000)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 sampled records, got %d:\n%s", len(lines), buf.String())
+	}
+
+	want := `level=DEBUG msg="message received" port="Keyboard 1" number=0 timestamp=2000 delta=1000 message="noteon ch=1 key=C4 vel=100"`
+	if !strings.HasSuffix(lines[1], want) {
+		t.Errorf("got %q, want suffix %q", lines[1], want)
+	}
+}
+
+func TestLogRateLimit(t *testing.T) {
+	r := logRateLimiter{perSecond: 2}
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false, false} {
+		if ok, _ := r.allow(now); ok != want {
+			t.Errorf("message %d: allowed %v, want %v", i, ok, want)
+		}
+	}
+
+	ok, suppressed := r.allow(now.Add(time.Second))
+	if !ok || suppressed != 2 {
+		t.Errorf("next second: allowed %v with %v suppressed, want true with 2", ok, suppressed)
+	}
+}
@@ -0,0 +1,395 @@
+// Package merge merges the messages of several MIDI in ports into one MIDI out port.
+package merge
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/minikomi/rtmididrv"
+)
+
+// Merger forwards the messages of several in ports (the sources) to one out port.
+//
+// A single goroutine sends all messages, so that every message is sent as a whole: a SysEx message
+// of one source is never interleaved with the messages of another source. SysEx messages that a source
+// delivers in chunks are collected and sent when they are complete.
+//
+// The messages are sent in the order of their timestamps. With a latency, the messages are held back for
+// that time, so that messages that arrive out of order from different sources can be sorted.
+//
+// Clock and synchronisation messages (MTC quarter frames, song position, timing clock, start, continue,
+// stop) and active sensing are only forwarded from the master source, so that the out port does not get
+// them once per source.
+type Merger struct {
+	out     connect.Out
+	ins     []connect.In
+	drv     *rtmididrv.Driver
+	master  int
+	latency int64
+	epoch   time.Time
+
+	mx      sync.Mutex
+	sources []*source
+	queue   eventQueue
+	seq     uint64
+	// lastSent is the timestamp of the last message sent
+	lastSent int64
+	closed   bool
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+// Stats are the counters of a source.
+type Stats struct {
+	// Received is the number of messages that have been received, counting SysEx chunks as one message.
+	Received uint64
+	// Sent is the number of messages that have been sent to the out port.
+	Sent uint64
+	// SysEx is the number of complete SysEx messages that have been sent.
+	SysEx uint64
+	// Filtered is the number of clock and active sensing messages that have not been forwarded,
+	// because the source is not the master.
+	Filtered uint64
+	// Dropped is the number of incomplete SysEx messages that have been dropped, because another
+	// message interrupted them or the merger has been closed.
+	Dropped uint64
+	// Late is the number of messages that arrived after a message with a later timestamp had been sent.
+	Late uint64
+	// Errors is the number of messages that could not be sent.
+	Errors uint64
+}
+
+type source struct {
+	index int
+	stats Stats
+	// sysex collects the chunks of an incomplete SysEx message
+	sysex []byte
+}
+
+// Option is an option for the merger.
+type Option func(*Merger)
+
+// Master sets the index of the source whose clock and synchronisation messages are forwarded.
+// The default is 0. With -1, these messages are not forwarded from any source.
+func Master(index int) Option {
+	return func(m *Merger) {
+		m.master = index
+	}
+}
+
+// Latency sets the time that messages are held back to sort them by their timestamps. The default is 0:
+// every message is sent as soon as it arrives.
+func Latency(d time.Duration) Option {
+	return func(m *Merger) {
+		m.latency = int64(d / time.Microsecond)
+	}
+}
+
+// Driver sets the driver of the sources. Sources that are in ports of the driver are then timestamped
+// with the timestamps of the driver (see rtmididrv.In.SetTimestampedListener). Without the driver,
+// or for other sources, the messages are timestamped when they arrive.
+func Driver(drv *rtmididrv.Driver) Option {
+	return func(m *Merger) {
+		m.drv = drv
+	}
+}
+
+// New returns a merger that forwards the messages of the in ports to the out port.
+// The ports are opened, if they are not open. The merger sets the listeners of the in ports.
+func New(out connect.Out, ins []connect.In, options ...Option) (*Merger, error) {
+	m := &Merger{
+		out:   out,
+		ins:   ins,
+		epoch: time.Now(),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	if m.master < -1 || m.master >= len(ins) && len(ins) > 0 {
+		return nil, fmt.Errorf("invalid master %v for %v sources", m.master, len(ins))
+	}
+
+	if !out.IsOpen() {
+		err := out.Open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range ins {
+		m.sources = append(m.sources, &source{index: i})
+	}
+
+	go m.run()
+
+	for i, in := range ins {
+		err := m.listen(in, m.sources[i])
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("can't listen to %s: %v", in.String(), err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Merger) listen(in connect.In, src *source) error {
+	if !in.IsOpen() {
+		err := in.Open()
+		if err != nil {
+			return err
+		}
+	}
+
+	if rtIn, ok := in.(rtmididrv.In); ok && m.drv != nil {
+		return rtIn.SetTimestampedListener(func(data []byte, timestampMicroseconds, _ int64) {
+			m.receive(src, data, timestampMicroseconds)
+		})
+	}
+
+	return in.SetListener(func(data []byte, _ int64) {
+		m.receive(src, data, m.now())
+	})
+}
+
+// now returns the current time in microseconds, on the clock of the driver, if there is one.
+func (m *Merger) now() int64 {
+	if m.drv != nil {
+		return m.drv.Now()
+	}
+	return int64(time.Since(m.epoch) / time.Microsecond)
+}
+
+// isSync reports whether the status is a clock or synchronisation message or active sensing.
+func isSync(status byte) bool {
+	switch status {
+	case 0xF1, 0xF2, 0xF8, 0xFA, 0xFB, 0xFC, 0xFE:
+		return true
+	}
+	return false
+}
+
+// receive handles a message of the source.
+func (m *Merger) receive(src *source, data []byte, ts int64) {
+	if len(data) == 0 {
+		return
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	src.stats.Received++
+
+	// real-time messages may appear within SysEx messages
+	if len(data) == 1 && data[0] >= 0xF8 {
+		m.accept(src, []byte{data[0]}, ts)
+		return
+	}
+
+	if src.sysex != nil {
+		if data[0] >= 0x80 && data[0] != 0xF7 {
+			// another message interrupted the SysEx
+			src.sysex = nil
+			src.stats.Dropped++
+		} else {
+			m.collect(src, data, ts)
+			return
+		}
+	}
+
+	if data[0] == 0xF0 && data[len(data)-1] != 0xF7 {
+		src.sysex = []byte{}
+		m.collect(src, data, ts)
+		return
+	}
+
+	m.accept(src, append([]byte(nil), data...), ts)
+}
+
+// collect adds a chunk to the SysEx of the source and accepts it, when it is complete.
+// The SysEx gets the timestamp of its last chunk. It must be called with the lock held.
+func (m *Merger) collect(src *source, data []byte, ts int64) {
+	for i, b := range data {
+		if b == 0xF7 {
+			msg := append(src.sysex, data[:i+1]...)
+			src.sysex = nil
+			m.accept(src, msg, ts)
+			return
+		}
+	}
+	src.sysex = append(src.sysex, data...)
+}
+
+// accept queues a message for sending. It must be called with the lock held.
+func (m *Merger) accept(src *source, data []byte, ts int64) {
+	if isSync(data[0]) && src.index != m.master {
+		src.stats.Filtered++
+		return
+	}
+
+	if ts < m.lastSent {
+		src.stats.Late++
+		ts = m.lastSent
+	}
+
+	m.seq++
+	heap.Push(&m.queue, &event{src: src, data: data, ts: ts, seq: m.seq})
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued messages when their time has come.
+func (m *Merger) run() {
+	defer close(m.done)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		m.mx.Lock()
+		var wait int64 = -1
+		for len(m.queue) > 0 {
+			ev := m.queue[0]
+			if !m.closed {
+				if d := ev.ts + m.latency - m.now(); d > 0 {
+					wait = d
+					break
+				}
+			}
+			heap.Pop(&m.queue)
+			m.send(ev)
+		}
+		closed := m.closed
+		m.mx.Unlock()
+
+		if closed {
+			return
+		}
+
+		if wait < 0 {
+			<-m.wake
+			continue
+		}
+
+		timer.Reset(time.Duration(wait) * time.Microsecond)
+		select {
+		case <-m.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends the message of the event. It is called with the lock held, so that the sources
+// wait while a message is sent.
+func (m *Merger) send(ev *event) {
+	m.lastSent = ev.ts
+
+	err := m.out.Send(ev.data)
+	if err != nil {
+		ev.src.stats.Errors++
+		return
+	}
+
+	ev.src.stats.Sent++
+	if ev.data[0] == 0xF0 {
+		ev.src.stats.SysEx++
+	}
+}
+
+// Stats returns the counters of the sources, in the order of the in ports.
+func (m *Merger) Stats() []Stats {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	stats := make([]Stats, len(m.sources))
+	for i, src := range m.sources {
+		stats[i] = src.stats
+	}
+	return stats
+}
+
+// Close stops listening to the in ports and sends the queued messages. Incomplete SysEx messages
+// are dropped. The ports are not closed.
+func (m *Merger) Close() error {
+	var errs rtmididrv.PortErrors
+	for _, in := range m.ins {
+		err := in.StopListening()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	m.mx.Lock()
+	if m.closed {
+		m.mx.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, src := range m.sources {
+		if src.sysex != nil {
+			src.sysex = nil
+			src.stats.Dropped++
+		}
+	}
+	m.mx.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	<-m.done
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// event is a message waiting to be sent.
+type event struct {
+	src  *source
+	data []byte
+	ts   int64
+	// seq keeps the order of messages with the same timestamp
+	seq uint64
+}
+
+// eventQueue is a heap of events, ordered by timestamp.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(a, b int) bool {
+	if q[a].ts != q[b].ts {
+		return q[a].ts < q[b].ts
+	}
+	return q[a].seq < q[b].seq
+}
+
+func (q eventQueue) Swap(a, b int) { q[a], q[b] = q[b], q[a] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
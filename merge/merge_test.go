@@ -0,0 +1,165 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomidi/connect"
+)
+
+type testIn struct {
+	sync.Mutex
+	number   int
+	open     bool
+	listener func([]byte, int64)
+}
+
+func (i *testIn) Open() error             { i.open = true; return nil }
+func (i *testIn) Close() error            { i.open = false; return nil }
+func (i *testIn) IsOpen() bool            { return i.open }
+func (i *testIn) Number() int             { return i.number }
+func (i *testIn) String() string          { return fmt.Sprintf("in %v", i.number) }
+func (i *testIn) Underlying() interface{} { return nil }
+
+func (i *testIn) SetListener(listener func([]byte, int64)) error {
+	i.Lock()
+	defer i.Unlock()
+	i.listener = listener
+	return nil
+}
+
+func (i *testIn) StopListening() error {
+	i.Lock()
+	defer i.Unlock()
+	i.listener = nil
+	return nil
+}
+
+func (i *testIn) receive(msgs ...[]byte) {
+	i.Lock()
+	defer i.Unlock()
+	for _, msg := range msgs {
+		if i.listener != nil {
+			i.listener(msg, 0)
+		}
+	}
+}
+
+type testOut struct {
+	sync.Mutex
+	open bool
+	msgs []string
+}
+
+func (o *testOut) Open() error             { o.open = true; return nil }
+func (o *testOut) Close() error            { o.open = false; return nil }
+func (o *testOut) IsOpen() bool            { return o.open }
+func (o *testOut) Number() int             { return 0 }
+func (o *testOut) String() string          { return "out" }
+func (o *testOut) Underlying() interface{} { return nil }
+
+func (o *testOut) Send(data []byte) error {
+	o.Lock()
+	defer o.Unlock()
+	o.msgs = append(o.msgs, fmt.Sprintf("% X", data))
+	return nil
+}
+
+func (o *testOut) sent() string {
+	o.Lock()
+	defer o.Unlock()
+	return strings.Join(o.msgs, ",")
+}
+
+func TestMerge(t *testing.T) {
+	var in0, in1 testIn
+	in1.number = 1
+	var out testOut
+
+	_, err := New(&out, []connect.In{&in0}, Master(1))
+	if err == nil {
+		t.Errorf("expected error for invalid master")
+	}
+
+	m, err := New(&out, []connect.In{&in0, &in1}, Master(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !in0.IsOpen() || !in1.IsOpen() || !out.IsOpen() {
+		t.Errorf("ports not opened")
+	}
+
+	in0.receive([]byte{0xF8}, []byte{0xF0, 0x43, 0x10})
+	in1.receive([]byte{0x90, 60, 100}, []byte{0xF8})
+	in0.receive([]byte{0xF8}, []byte{0x4C, 0x00}, []byte{0xFE}, []byte{0x7E, 0xF7})
+	in1.receive([]byte{0x80, 60, 0})
+	// interrupted SysEx
+	in0.receive([]byte{0xF0, 0x01}, []byte{0x91, 62, 100})
+
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the SysEx is sent as a whole when it is complete; the clock of in0 is filtered
+	want := "90 3C 64,F8,F0 43 10 4C 00 7E F7,80 3C 00,91 3E 64"
+	if got := out.sent(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	stats := m.Stats()
+	if s := stats[0]; s.Received != 8 || s.Sent != 2 || s.SysEx != 1 || s.Filtered != 3 || s.Dropped != 1 {
+		t.Errorf("stats of in0: %+v", s)
+	}
+	if s := stats[1]; s.Received != 3 || s.Sent != 3 || s.Filtered != 0 {
+		t.Errorf("stats of in1: %+v", s)
+	}
+
+	// closed
+	in1.receive([]byte{0x90, 60, 100})
+	if got := out.sent(); got != want {
+		t.Errorf("sent after close: %s", got)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	var in0, in1 testIn
+	var out testOut
+
+	m, err := New(&out, []connect.In{&in0, &in1}, Latency(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// in1 delivers late: the messages are sorted by their timestamps
+	now := m.now()
+	m.receive(m.sources[0], []byte{0x90, 1, 1}, now+10)
+	m.receive(m.sources[1], []byte{0x90, 2, 2}, now)
+	m.receive(m.sources[0], []byte{0x90, 3, 3}, now+20)
+	m.receive(m.sources[1], []byte{0x90, 4, 4}, now+10)
+
+	if got := out.sent(); got != "" {
+		t.Errorf("sent before the latency: %s", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(out.sent()) < 4*8+3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	want := "90 02 02,90 01 01,90 04 04,90 03 03"
+	if got := out.sent(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	m.receive(m.sources[1], []byte{0x90, 5, 5}, now)
+	m.Close()
+
+	if got := m.Stats()[1].Late; got != 1 {
+		t.Errorf("expected 1 late message, got %v", got)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Package miditext converts MIDI messages from and to a human-readable text notation.
+It is used for logs, monitors, command line tools, configuration files and tests.
+
+A message is written as its name, followed by arguments in the form key=value, separated by spaces.
+Names and keys are case insensitive; the arguments may be given in any order, but all of them are required.
+Values are decimal numbers or, with the prefix 0x, hex numbers. Channels are counted from 1 to 16.
+Keys may also be given as note names with octave, where C4 is 60 (middle C), e.g. C-1 (0), F#3 or Bb2.
+
+Channel messages:
+
+	noteoff ch=1 key=C4 vel=64        80-8F key vel
+	noteon ch=1 key=C4 vel=100        90-9F key vel
+	polyat ch=1 key=C4 pressure=20    A0-AF key pressure (polyphonic aftertouch)
+	cc ch=1 num=7 val=100             B0-BF num val (control change, including channel mode messages)
+	pc ch=1 prog=5                    C0-CF prog (program change)
+	at ch=1 pressure=20               D0-DF pressure (channel aftertouch)
+	pb ch=1 val=-8192                 E0-EF lsb msb (pitch bend from -8192 to 8191, 0 is the center)
+
+System common messages:
+
+	sysex 41 10 42 12                 F0 41 10 42 12 F7 (the payload as hex bytes, without F0 and F7)
+	mtc type=1 val=5                  F1 (type<<4 | val) (MIDI time code quarter frame)
+	spp pos=16                        F2 lsb msb (song position pointer in sixteenth notes, 0 to 16383)
+	songselect song=3                 F3 song
+	tunerequest                       F6
+
+System realtime messages:
+
+	clock                             F8
+	start                             FA
+	continue                          FB
+	stop                              FC
+	activesense                       FE
+	reset                             FF
+
+Everything else, e.g. incomplete messages, undefined status bytes or data bytes without status, is written as
+raw hex bytes:
+
+	raw F4
+	raw 90 3C
+
+The hex bytes of sysex and raw may also be written without spaces (raw 903C).
+
+Format always writes note names, decimal values and upper case hex bytes, so that its output is canonical:
+Parse(Format(msg)) returns msg for every byte slice and Format(Parse(text)) returns text for every
+text that Format has written.
+
+ParseAll parses several messages from a text with one message per line (or separated by semicolons)
+and comments that start with #. Message implements encoding.TextMarshaler and encoding.TextUnmarshaler,
+so that messages can be written in the text notation in JSON and other formats.
+*/
+package miditext
@@ -0,0 +1,127 @@
+package miditext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format returns the text of the given MIDI message in the notation described in the package documentation, e.g.
+//
+//	noteon ch=1 key=C4 vel=100
+//
+// Messages that can't be decoded are shown as raw hex bytes, e.g.
+//
+//	raw 90 3C
+//
+// Parse(Format(msg)) returns msg for every byte slice.
+func Format(msg []byte) string {
+	if len(msg) == 0 || msg[0] < 0x80 || !dataBytes(msg[1:]) && msg[0] != 0xF0 {
+		return raw(msg)
+	}
+
+	status := msg[0]
+	if status < 0xF0 {
+		return formatChannel(status, msg[1:])
+	}
+
+	switch status {
+	case 0xF0:
+		if len(msg) < 2 || msg[len(msg)-1] != 0xF7 || !dataBytes(msg[1:len(msg)-1]) {
+			return raw(msg)
+		}
+		if len(msg) == 2 {
+			return "sysex"
+		}
+		return "sysex " + hex(msg[1:len(msg)-1])
+	case 0xF1:
+		if len(msg) == 2 {
+			return fmt.Sprintf("mtc type=%d val=%d", msg[1]>>4, msg[1]&0x0F)
+		}
+	case 0xF2:
+		if len(msg) == 3 {
+			return fmt.Sprintf("spp pos=%d", int(msg[1])|int(msg[2])<<7)
+		}
+	case 0xF3:
+		if len(msg) == 2 {
+			return fmt.Sprintf("songselect song=%d", msg[1])
+		}
+	default:
+		if name, has := singleByte[status]; has && len(msg) == 1 {
+			return name
+		}
+	}
+
+	return raw(msg)
+}
+
+var singleByte = map[byte]string{
+	0xF6: "tunerequest",
+	0xF8: "clock",
+	0xFA: "start",
+	0xFB: "continue",
+	0xFC: "stop",
+	0xFE: "activesense",
+	0xFF: "reset",
+}
+
+func formatChannel(status byte, data []byte) string {
+	ch := status&0x0F + 1
+
+	switch status & 0xF0 {
+	case 0xC0:
+		if len(data) == 1 {
+			return fmt.Sprintf("pc ch=%d prog=%d", ch, data[0])
+		}
+	case 0xD0:
+		if len(data) == 1 {
+			return fmt.Sprintf("at ch=%d pressure=%d", ch, data[0])
+		}
+	default:
+		if len(data) != 2 {
+			break
+		}
+		switch status & 0xF0 {
+		case 0x80:
+			return fmt.Sprintf("noteoff ch=%d key=%s vel=%d", ch, NoteName(data[0]), data[1])
+		case 0x90:
+			return fmt.Sprintf("noteon ch=%d key=%s vel=%d", ch, NoteName(data[0]), data[1])
+		case 0xA0:
+			return fmt.Sprintf("polyat ch=%d key=%s pressure=%d", ch, NoteName(data[0]), data[1])
+		case 0xB0:
+			return fmt.Sprintf("cc ch=%d num=%d val=%d", ch, data[0], data[1])
+		case 0xE0:
+			return fmt.Sprintf("pb ch=%d val=%d", ch, int(data[0])|int(data[1])<<7-8192)
+		}
+	}
+
+	return raw(append([]byte{status}, data...))
+}
+
+// dataBytes reports whether all bytes are data bytes.
+func dataBytes(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func raw(msg []byte) string {
+	if len(msg) == 0 {
+		return "raw"
+	}
+	return "raw " + hex(msg)
+}
+
+// hex returns the bytes as upper case hex numbers separated by spaces.
+func hex(b []byte) string {
+	var sb strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02X", c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,62 @@
+package miditext
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		want string
+	}{
+		{[]byte{0x90, 60, 100}, "noteon ch=1 key=C4 vel=100"},
+		{[]byte{0x8F, 0, 0}, "noteoff ch=16 key=C-1 vel=0"},
+		{[]byte{0xA2, 127, 5}, "polyat ch=3 key=G9 pressure=5"},
+		{[]byte{0xB0, 7, 100}, "cc ch=1 num=7 val=100"},
+		{[]byte{0xC1, 12}, "pc ch=2 prog=12"},
+		{[]byte{0xD0, 64}, "at ch=1 pressure=64"},
+		{[]byte{0xE0, 0, 64}, "pb ch=1 val=0"},
+		{[]byte{0xE0, 0, 0}, "pb ch=1 val=-8192"},
+		{[]byte{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}, "sysex 7E 7F 09 01"},
+		{[]byte{0xF0, 0xF7}, "sysex"},
+		{[]byte{0xF1, 0x35}, "mtc type=3 val=5"},
+		{[]byte{0xF2, 0x10, 0x01}, "spp pos=144"},
+		{[]byte{0xF3, 4}, "songselect song=4"},
+		{[]byte{0xF8}, "clock"},
+		{[]byte{0xFE}, "activesense"},
+		{nil, "raw"},
+		{[]byte{0x90, 60}, "raw 90 3C"},
+		{[]byte{0x90, 60, 0x80}, "raw 90 3C 80"},
+		{[]byte{0xF0, 0x7E}, "raw F0 7E"},
+		{[]byte{0xF4}, "raw F4"},
+		{[]byte{0x3C}, "raw 3C"},
+	}
+
+	for _, test := range tests {
+		if got := Format(test.msg); got != test.want {
+			t.Errorf("Format(% X) = %q, want %q", test.msg, got, test.want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	if got := NoteName(61); got != "C#4" {
+		t.Errorf("NoteName(61) = %q", got)
+	}
+
+	if got := ControllerName(39); got != "Volume LSB" {
+		t.Errorf("ControllerName(39) = %q", got)
+	}
+
+	if got := ControllerName(3); got != "" {
+		t.Errorf("ControllerName(3) = %q", got)
+	}
+
+	if got := ManufacturerName(ManufacturerID([]byte{0x00, 0x20, 0x29, 0x01})); got != "Focusrite/Novation" {
+		t.Errorf("ManufacturerName = %q", got)
+	}
+
+	if got := ManufacturerName(ManufacturerID([]byte{0x41, 0x10})); got != "Roland" {
+		t.Errorf("ManufacturerName = %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+package miditext
+
+// Message is a MIDI message that is marshaled to and unmarshaled from the text notation.
+type Message []byte
+
+// String returns the text of the message.
+func (m Message) String() string {
+	return Format(m)
+}
+
+// MarshalText returns the text of the message.
+func (m Message) MarshalText() ([]byte, error) {
+	return []byte(Format(m)), nil
+}
+
+// UnmarshalText sets the message to the one of the text.
+func (m *Message) UnmarshalText(text []byte) error {
+	msg, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*m = msg
+	return nil
+}
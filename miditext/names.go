@@ -0,0 +1,135 @@
+package miditext
+
+import (
+	"strconv"
+)
+
+var noteNames = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// NoteName returns the name of the given MIDI key with its octave, where key 60 is C4.
+// Keys above 127 are returned as numbers.
+func NoteName(key uint8) string {
+	if key > 127 {
+		return strconv.Itoa(int(key))
+	}
+	return noteNames[key%12] + strconv.Itoa(int(key)/12-1)
+}
+
+var controllerNames = map[uint8]string{
+	0:   "Bank Select",
+	1:   "Modulation",
+	2:   "Breath Controller",
+	4:   "Foot Controller",
+	5:   "Portamento Time",
+	6:   "Data Entry",
+	7:   "Volume",
+	8:   "Balance",
+	10:  "Pan",
+	11:  "Expression",
+	12:  "Effect Control 1",
+	13:  "Effect Control 2",
+	16:  "General Purpose 1",
+	17:  "General Purpose 2",
+	18:  "General Purpose 3",
+	19:  "General Purpose 4",
+	64:  "Sustain",
+	65:  "Portamento",
+	66:  "Sostenuto",
+	67:  "Soft Pedal",
+	68:  "Legato",
+	69:  "Hold 2",
+	70:  "Sound Variation",
+	71:  "Resonance",
+	72:  "Release Time",
+	73:  "Attack Time",
+	74:  "Cutoff",
+	75:  "Decay Time",
+	76:  "Vibrato Rate",
+	77:  "Vibrato Depth",
+	78:  "Vibrato Delay",
+	79:  "Sound Controller 10",
+	80:  "General Purpose 5",
+	81:  "General Purpose 6",
+	82:  "General Purpose 7",
+	83:  "General Purpose 8",
+	84:  "Portamento Control",
+	88:  "High Resolution Velocity Prefix",
+	91:  "Reverb",
+	92:  "Tremolo",
+	93:  "Chorus",
+	94:  "Detune",
+	95:  "Phaser",
+	96:  "Data Increment",
+	97:  "Data Decrement",
+	98:  "NRPN LSB",
+	99:  "NRPN MSB",
+	100: "RPN LSB",
+	101: "RPN MSB",
+	120: "All Sound Off",
+	121: "Reset All Controllers",
+	122: "Local Control",
+	123: "All Notes Off",
+	124: "Omni Off",
+	125: "Omni On",
+	126: "Mono On",
+	127: "Poly On",
+}
+
+// ControllerName returns the name of the given control change number, as defined by the MIDI 1.0 specification.
+// The numbers 32 to 63 are the LSBs of the controllers 0 to 31. Undefined numbers have no name.
+func ControllerName(num uint8) string {
+	if num >= 32 && num < 64 {
+		if name, has := controllerNames[num-32]; has {
+			return name + " LSB"
+		}
+		return ""
+	}
+	return controllerNames[num]
+}
+
+var manufacturerNames = map[string]string{
+	"\x01":         "Sequential",
+	"\x04":         "Moog",
+	"\x06":         "Lexicon",
+	"\x07":         "Kurzweil",
+	"\x0F":         "Ensoniq",
+	"\x10":         "Oberheim",
+	"\x18":         "E-mu",
+	"\x3E":         "Waldorf",
+	"\x40":         "Kawai",
+	"\x41":         "Roland",
+	"\x42":         "Korg",
+	"\x43":         "Yamaha",
+	"\x44":         "Casio",
+	"\x47":         "Akai",
+	"\x7D":         "Non-Commercial",
+	"\x7E":         "Universal Non-Real Time",
+	"\x7F":         "Universal Real Time",
+	"\x00\x00\x0E": "Alesis",
+	"\x00\x20\x1F": "TC Electronic",
+	"\x00\x20\x29": "Focusrite/Novation",
+	"\x00\x20\x32": "Behringer",
+	"\x00\x20\x33": "Access Music",
+	"\x00\x20\x3C": "Elektron",
+	"\x00\x20\x6B": "Arturia",
+}
+
+// ManufacturerID returns the manufacturer ID at the start of the given SysEx payload (without the leading 0xF0):
+// one byte, or three bytes if the first is 0. It returns nil, if the payload is too short.
+func ManufacturerID(payload []byte) []byte {
+	switch {
+	case len(payload) == 0:
+		return nil
+	case payload[0] != 0:
+		return payload[:1]
+	case len(payload) >= 3:
+		return payload[:3]
+	default:
+		return nil
+	}
+}
+
+// ManufacturerName returns the name of the manufacturer with the given SysEx ID, or "" if it is unknown.
+func ManufacturerName(id []byte) string {
+	return manufacturerNames[string(id)]
+}
@@ -0,0 +1,283 @@
+package miditext
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse returns the MIDI message for the given text in the notation described in the package documentation.
+func Parse(text string) ([]byte, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty message")
+	}
+
+	name, args := strings.ToLower(fields[0]), fields[1:]
+
+	switch name {
+	case "raw":
+		return parseHex(args)
+	case "sysex":
+		payload, err := parseHex(args)
+		if err != nil {
+			return nil, err
+		}
+		if !dataBytes(payload) {
+			return nil, fmt.Errorf("invalid SysEx payload: only data bytes (00-7F) are allowed")
+		}
+		msg := append([]byte{0xF0}, payload...)
+		return append(msg, 0xF7), nil
+	}
+
+	for status, n := range singleByte {
+		if n == name {
+			if len(args) > 0 {
+				return nil, fmt.Errorf("%s takes no arguments", name)
+			}
+			return []byte{status}, nil
+		}
+	}
+
+	m, ok := messages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown message %q", fields[0])
+	}
+
+	values, err := parseArgs(name, args, m)
+	if err != nil {
+		return nil, err
+	}
+	return m.build(values), nil
+}
+
+// message describes a message with arguments.
+type message struct {
+	// args are the names of the arguments, all of which are required
+	args []string
+	// ranges are the minimum and maximum of the arguments
+	ranges [][2]int
+	build  func(v []int) []byte
+}
+
+var messages = map[string]message{
+	"noteoff": {
+		args:   []string{"ch", "key", "vel"},
+		ranges: [][2]int{{1, 16}, {0, 127}, {0, 127}},
+		build:  channelMessage(0x80),
+	},
+	"noteon": {
+		args:   []string{"ch", "key", "vel"},
+		ranges: [][2]int{{1, 16}, {0, 127}, {0, 127}},
+		build:  channelMessage(0x90),
+	},
+	"polyat": {
+		args:   []string{"ch", "key", "pressure"},
+		ranges: [][2]int{{1, 16}, {0, 127}, {0, 127}},
+		build:  channelMessage(0xA0),
+	},
+	"cc": {
+		args:   []string{"ch", "num", "val"},
+		ranges: [][2]int{{1, 16}, {0, 127}, {0, 127}},
+		build:  channelMessage(0xB0),
+	},
+	"pc": {
+		args:   []string{"ch", "prog"},
+		ranges: [][2]int{{1, 16}, {0, 127}},
+		build:  channelMessage(0xC0),
+	},
+	"at": {
+		args:   []string{"ch", "pressure"},
+		ranges: [][2]int{{1, 16}, {0, 127}},
+		build:  channelMessage(0xD0),
+	},
+	"pb": {
+		args:   []string{"ch", "val"},
+		ranges: [][2]int{{1, 16}, {-8192, 8191}},
+		build: func(v []int) []byte {
+			val := v[1] + 8192
+			return []byte{0xE0 | byte(v[0]-1), byte(val & 0x7F), byte(val >> 7)}
+		},
+	},
+	"mtc": {
+		args:   []string{"type", "val"},
+		ranges: [][2]int{{0, 7}, {0, 15}},
+		build: func(v []int) []byte {
+			return []byte{0xF1, byte(v[0]<<4 | v[1])}
+		},
+	},
+	"spp": {
+		args:   []string{"pos"},
+		ranges: [][2]int{{0, 16383}},
+		build: func(v []int) []byte {
+			return []byte{0xF2, byte(v[0] & 0x7F), byte(v[0] >> 7)}
+		},
+	},
+	"songselect": {
+		args:   []string{"song"},
+		ranges: [][2]int{{0, 127}},
+		build: func(v []int) []byte {
+			return []byte{0xF3, byte(v[0])}
+		},
+	},
+}
+
+// channelMessage returns a build function for a channel message with the given status.
+// The first value is the channel (1-16), the others are the data bytes.
+func channelMessage(status byte) func(v []int) []byte {
+	return func(v []int) []byte {
+		msg := []byte{status | byte(v[0]-1)}
+		for _, d := range v[1:] {
+			msg = append(msg, byte(d))
+		}
+		return msg
+	}
+}
+
+func parseArgs(name string, args []string, m message) ([]int, error) {
+	values := make([]int, len(m.args))
+	set := make([]bool, len(m.args))
+
+	for _, arg := range args {
+		idx := strings.IndexByte(arg, '=')
+		if idx < 0 {
+			return nil, fmt.Errorf("%s: invalid argument %q: must be key=value", name, arg)
+		}
+		key, val := strings.ToLower(arg[:idx]), arg[idx+1:]
+
+		i := indexOf(m.args, key)
+		if i < 0 {
+			return nil, fmt.Errorf("%s: unknown argument %q", name, key)
+		}
+		if set[i] {
+			return nil, fmt.Errorf("%s: argument %q given twice", name, key)
+		}
+
+		v, err := parseValue(key, val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		if v < m.ranges[i][0] || v > m.ranges[i][1] {
+			return nil, fmt.Errorf("%s: %s=%v out of range %v..%v", name, key, v, m.ranges[i][0], m.ranges[i][1])
+		}
+
+		values[i], set[i] = v, true
+	}
+
+	for i, key := range m.args {
+		if !set[i] {
+			return nil, fmt.Errorf("%s: missing argument %q", name, key)
+		}
+	}
+
+	return values, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, l := range list {
+		if l == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseValue parses a decimal or hex number, or a note name if key is "key".
+func parseValue(key, val string) (int, error) {
+	if key == "key" {
+		if n, err := ParseNoteName(val); err == nil {
+			return int(n), nil
+		}
+	}
+
+	var v int64
+	var err error
+	if strings.HasPrefix(strings.ToLower(val), "0x") {
+		v, err = strconv.ParseInt(val[2:], 16, 32)
+	} else {
+		v, err = strconv.ParseInt(val, 10, 32)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %q", key, val)
+	}
+	return int(v), nil
+}
+
+var noteOffsets = map[byte]int{'c': 0, 'd': 2, 'e': 4, 'f': 5, 'g': 7, 'a': 9, 'b': 11}
+
+// ParseNoteName returns the MIDI key for a note name like C4 (60), F#3, Bb2 or C-1 (0).
+func ParseNoteName(name string) (uint8, error) {
+	s := strings.ToLower(name)
+	if s == "" {
+		return 0, fmt.Errorf("invalid note name %q", name)
+	}
+
+	offset, ok := noteOffsets[s[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid note name %q", name)
+	}
+	s = s[1:]
+
+	switch {
+	case strings.HasPrefix(s, "#"):
+		offset++
+		s = s[1:]
+	case strings.HasPrefix(s, "b") && len(s) > 1:
+		offset--
+		s = s[1:]
+	}
+
+	octave, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid note name %q", name)
+	}
+
+	key := (octave+1)*12 + offset
+	if key < 0 || key > 127 {
+		return 0, fmt.Errorf("note %q out of range", name)
+	}
+	return uint8(key), nil
+}
+
+// ParseAll returns the messages of a text with one message per line or several messages separated by semicolons.
+// Empty lines and comments, that start with # and go to the end of the line, are skipped.
+func ParseAll(text string) ([][]byte, error) {
+	var msgs [][]byte
+
+	for i, line := range strings.Split(text, "\n") {
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		for _, m := range strings.Split(line, ";") {
+			if strings.TrimSpace(m) == "" {
+				continue
+			}
+			msg, err := Parse(m)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", i+1, err)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return msgs, nil
+}
+
+// parseHex parses hex bytes, given as separate fields ("F0 7E") or in one ("F07E").
+func parseHex(fields []string) ([]byte, error) {
+	var b []byte
+	for _, f := range fields {
+		if len(f)%2 != 0 {
+			return nil, fmt.Errorf("invalid hex bytes %q", f)
+		}
+		for i := 0; i < len(f); i += 2 {
+			v, err := strconv.ParseUint(f[i:i+2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex bytes %q", f)
+			}
+			b = append(b, byte(v))
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,142 @@
+package miditext
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want []byte
+	}{
+		{"noteon ch=1 key=C4 vel=100", []byte{0x90, 60, 100}},
+		{"NoteOn vel=100 key=61 ch=16", []byte{0x9F, 61, 100}},
+		{"noteoff ch=2 key=Bb2 vel=0", []byte{0x81, 46, 0}},
+		{"cc ch=1 num=7 val=127", []byte{0xB0, 7, 127}},
+		{"pb ch=1 val=8191", []byte{0xE0, 0x7F, 0x7F}},
+		{"pb ch=1 val=-8192", []byte{0xE0, 0, 0}},
+		{"sysex 7E 7F 0901", []byte{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}},
+		{"spp pos=144", []byte{0xF2, 0x10, 0x01}},
+		{"clock", []byte{0xF8}},
+		{"raw 90 3C", []byte{0x90, 0x3C}},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.text)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.text, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("Parse(%q) = % X, want % X", test.text, got, test.want)
+		}
+	}
+
+	for _, text := range []string{
+		"",
+		"noteon ch=1 key=C4",
+		"noteon ch=0 key=C4 vel=100",
+		"noteon ch=1 key=H4 vel=100",
+		"noteon ch=1 key=C4 vel=100 vel=100",
+		"noteon ch=1 key=C4 vel=100 foo=1",
+		"sysex 7E F7",
+		"clock 1",
+		"raw 9",
+		"hello",
+	} {
+		if msg, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) = % X, want error", text, msg)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	check := func(msg []byte) {
+		text := Format(msg)
+		got, err := Parse(text)
+		if err != nil {
+			t.Fatalf("Parse(Format(% X)) = Parse(%q): %v", msg, text, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("Parse(Format(% X)) = Parse(%q) = % X", msg, text, got)
+		}
+		if again := Format(got); again != text {
+			t.Fatalf("Format(Parse(%q)) = %q", text, again)
+		}
+	}
+
+	// every message of up to 2 bytes and 3 byte messages with the edge values as last byte
+	for a := 0; a < 256; a++ {
+		check([]byte{byte(a)})
+		for b := 0; b < 256; b++ {
+			check([]byte{byte(a), byte(b)})
+			for _, c := range []byte{0x00, 0x01, 0x40, 0x7F, 0x80, 0xF7, 0xFF} {
+				check([]byte{byte(a), byte(b), c})
+			}
+		}
+	}
+
+	check(nil)
+	check([]byte{0xF0, 0xF7})
+	check([]byte{0xF0, 0x43, 0x10, 0x4C, 0x00, 0x00, 0x7E, 0x00, 0xF7})
+	check([]byte{0xF0, 0x43, 0xF7, 0xF7})
+	check([]byte{0xF0, 0x43, 0x90, 0xF7})
+	check([]byte{0x90, 0x3C, 0x64, 0x3E, 0x64})
+}
+
+func TestParseAll(t *testing.T) {
+	text := `
+# reset the synth
+cc ch=1 num=121 val=0; cc ch=1 num=0 val=0x10
+pc ch=1 prog=5 # piano
+
+sysex 7E7F0901
+`
+	msgs, err := ParseAll(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]byte{
+		{0xB0, 121, 0},
+		{0xB0, 0, 16},
+		{0xC0, 5},
+		{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7},
+	}
+
+	if len(msgs) != len(want) {
+		t.Fatalf("got %v messages, want %v", len(msgs), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(msgs[i], want[i]) {
+			t.Errorf("message %v: got % X, want % X", i, msgs[i], want[i])
+		}
+	}
+
+	if _, err := ParseAll("clock\nnoteon ch=1"); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected error in line 2, got %v", err)
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	var v struct {
+		Messages []Message
+	}
+
+	err := json.Unmarshal([]byte(`{"Messages": ["noteon ch=10 key=36 vel=127", "raw F5"]}`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(out), `{"Messages":["noteon ch=10 key=C2 vel=127","raw F5"]}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
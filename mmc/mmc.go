@@ -0,0 +1,184 @@
+// Package mmc builds, sends and parses MIDI Machine Control (MMC) messages.
+//
+// MMC messages are universal real-time SysEx messages: commands are F0 7F <device> 06 <commands> F7,
+// responses F0 7F <device> 07 <responses> F7. A message can hold several commands or responses.
+// The device ID 7F addresses all devices.
+package mmc
+
+import (
+	"fmt"
+
+	"github.com/minikomi/rtmididrv/mtc"
+)
+
+// AllDevices is the device ID that addresses all devices.
+const AllDevices = 0x7F
+
+const (
+	subIDCommand  = 0x06
+	subIDResponse = 0x07
+)
+
+// CommandType is the code of an MMC command.
+type CommandType byte
+
+// MMC commands.
+const (
+	CmdStop              CommandType = 0x01
+	CmdPlay              CommandType = 0x02
+	CmdDeferredPlay      CommandType = 0x03
+	CmdFastForward       CommandType = 0x04
+	CmdRewind            CommandType = 0x05
+	CmdRecordStrobe      CommandType = 0x06
+	CmdRecordExit        CommandType = 0x07
+	CmdRecordPause       CommandType = 0x08
+	CmdPause             CommandType = 0x09
+	CmdEject             CommandType = 0x0A
+	CmdChase             CommandType = 0x0B
+	CmdCommandErrorReset CommandType = 0x0C
+	CmdReset             CommandType = 0x0D
+	CmdWrite             CommandType = 0x40
+	CmdMaskedWrite       CommandType = 0x41
+	CmdRead              CommandType = 0x42
+	CmdUpdate            CommandType = 0x43
+	CmdLocate            CommandType = 0x44
+	CmdVariablePlay      CommandType = 0x45
+	CmdSearch            CommandType = 0x46
+	CmdShuttle           CommandType = 0x47
+	CmdStep              CommandType = 0x48
+)
+
+var commandNames = map[CommandType]string{
+	CmdStop:              "stop",
+	CmdPlay:              "play",
+	CmdDeferredPlay:      "deferred_play",
+	CmdFastForward:       "fast_forward",
+	CmdRewind:            "rewind",
+	CmdRecordStrobe:      "record_strobe",
+	CmdRecordExit:        "record_exit",
+	CmdRecordPause:       "record_pause",
+	CmdPause:             "pause",
+	CmdEject:             "eject",
+	CmdChase:             "chase",
+	CmdCommandErrorReset: "command_error_reset",
+	CmdReset:             "reset",
+	CmdWrite:             "write",
+	CmdMaskedWrite:       "masked_write",
+	CmdRead:              "read",
+	CmdUpdate:            "update",
+	CmdLocate:            "locate",
+	CmdVariablePlay:      "variable_play",
+	CmdSearch:            "search",
+	CmdShuttle:           "shuttle",
+	CmdStep:              "step",
+}
+
+func (c CommandType) String() string {
+	if name, has := commandNames[c]; has {
+		return name
+	}
+	return fmt.Sprintf("command_%02X", byte(c))
+}
+
+// hasData reports whether commands and responses with the given code are followed by a count and data bytes.
+func hasData(code byte) bool {
+	return code >= 0x40 && code <= 0x77
+}
+
+// Command is an MMC command.
+type Command struct {
+	// Device is the device ID of the message.
+	Device byte
+	Type   CommandType
+	// Data are the data bytes of commands from 40 to 77, without the count.
+	Data []byte
+}
+
+func (c Command) String() string {
+	if len(c.Data) == 0 {
+		return fmt.Sprintf("%v device=%v", c.Type, c.Device)
+	}
+	return fmt.Sprintf("%v device=%v data=% X", c.Type, c.Device, c.Data)
+}
+
+// Message returns the SysEx message of the command.
+func (c Command) Message() []byte {
+	return Message(c.Device, c)
+}
+
+// Message returns a SysEx message with one or more commands for the given device.
+// The device IDs of the commands are ignored.
+func Message(device byte, commands ...Command) []byte {
+	msg := []byte{0xF0, 0x7F, device & 0x7F, subIDCommand}
+	for _, c := range commands {
+		msg = append(msg, byte(c.Type))
+		if hasData(byte(c.Type)) {
+			msg = append(msg, byte(len(c.Data)))
+			msg = append(msg, c.Data...)
+		}
+	}
+	return append(msg, 0xF7)
+}
+
+// Locate returns a locate command to the given timecode and subframe (0-99).
+func Locate(device byte, t mtc.Timecode, subframe int) Command {
+	return Command{
+		Device: device,
+		Type:   CmdLocate,
+		// target subcommand with standard time code
+		Data: append([]byte{0x01}, timecodeBytes(t, subframe)...),
+	}
+}
+
+// Step returns a step command. Negative steps step backwards.
+func Step(device byte, steps int) Command {
+	var b byte
+	if steps < 0 {
+		b = 0x40
+		steps = -steps
+	}
+	if steps > 0x3F {
+		steps = 0x3F
+	}
+	return Command{Device: device, Type: CmdStep, Data: []byte{b | byte(steps)}}
+}
+
+// Timecode returns the target of a locate command.
+func (c Command) Timecode() (t mtc.Timecode, subframe int, ok bool) {
+	if c.Type != CmdLocate || len(c.Data) != 6 || c.Data[0] != 0x01 {
+		return mtc.Timecode{}, 0, false
+	}
+	return parseTimecode(c.Data[1:])
+}
+
+// Steps returns the steps of a step command.
+func (c Command) Steps() (int, bool) {
+	if c.Type != CmdStep || len(c.Data) != 1 {
+		return 0, false
+	}
+	steps := int(c.Data[0] & 0x3F)
+	if c.Data[0]&0x40 != 0 {
+		steps = -steps
+	}
+	return steps, true
+}
+
+// timecodeBytes returns the standard time code of MMC: hours with the rate, minutes, seconds, frames, subframes.
+func timecodeBytes(t mtc.Timecode, subframe int) []byte {
+	return []byte{byte(t.Rate)<<5 | byte(t.Hours), byte(t.Minutes), byte(t.Seconds), byte(t.Frames), byte(subframe)}
+}
+
+// parseTimecode parses a standard time code. The flags in the frames byte are ignored.
+func parseTimecode(data []byte) (t mtc.Timecode, subframe int, ok bool) {
+	t = mtc.Timecode{
+		Hours:   int(data[0] & 0x1F),
+		Minutes: int(data[1] & 0x3F),
+		Seconds: int(data[2] & 0x3F),
+		Frames:  int(data[3] & 0x1F),
+		Rate:    mtc.Rate(data[0] >> 5 & 0x03),
+	}
+	if !t.Valid() {
+		return mtc.Timecode{}, 0, false
+	}
+	return t, int(data[4] & 0x7F), true
+}
@@ -0,0 +1,113 @@
+package mmc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/minikomi/rtmididrv/mtc"
+)
+
+type testOut struct {
+	msgs [][]byte
+}
+
+func (o *testOut) Send(data []byte) error {
+	o.msgs = append(o.msgs, append([]byte(nil), data...))
+	return nil
+}
+
+func TestSender(t *testing.T) {
+	out := &testOut{}
+	s := NewSender(out, 0x10)
+
+	s.Play()
+	s.RecordStrobe()
+	s.Locate(mtc.Timecode{Hours: 1, Minutes: 2, Seconds: 3, Frames: 4, Rate: mtc.FPS25}, 5)
+	s.Step(-3)
+	s.Send(Command{Type: CmdStop}, Command{Type: CmdRewind})
+
+	expected := [][]byte{
+		{0xF0, 0x7F, 0x10, 0x06, 0x02, 0xF7},
+		{0xF0, 0x7F, 0x10, 0x06, 0x06, 0xF7},
+		{0xF0, 0x7F, 0x10, 0x06, 0x44, 0x06, 0x01, 0x21, 0x02, 0x03, 0x04, 0x05, 0xF7},
+		{0xF0, 0x7F, 0x10, 0x06, 0x48, 0x01, 0x43, 0xF7},
+		{0xF0, 0x7F, 0x10, 0x06, 0x01, 0x05, 0xF7},
+	}
+
+	if len(out.msgs) != len(expected) {
+		t.Fatalf("got %v messages, expected %v", len(out.msgs), len(expected))
+	}
+	for i, msg := range out.msgs {
+		if !bytes.Equal(msg, expected[i]) {
+			t.Errorf("message %v: got % X, expected % X", i, msg, expected[i])
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tc := mtc.Timecode{Hours: 10, Minutes: 20, Seconds: 30, Frames: 12, Rate: mtc.FPS2997DF}
+
+	commands, responses, ok := Parse(Message(AllDevices, Command{Type: CmdPlay}, Locate(0, tc, 50), Step(0, 7)))
+	if !ok || len(responses) != 0 || len(commands) != 3 {
+		t.Fatalf("commands: %v %v %v", commands, responses, ok)
+	}
+	if commands[0].Type != CmdPlay || commands[0].Device != AllDevices {
+		t.Errorf("first command: %v", commands[0])
+	}
+	if got, sub, ok := commands[1].Timecode(); !ok || got != tc || sub != 50 {
+		t.Errorf("locate: %v %v %v", got, sub, ok)
+	}
+	if steps, ok := commands[2].Steps(); !ok || steps != 7 {
+		t.Errorf("step: %v %v", steps, ok)
+	}
+
+	msg := ResponseMessage(3,
+		TimecodeResponse(3, RespSelectedTimeCode, tc, 0),
+		Response{Type: RespMotionControlTally, Data: []byte{0x01, 0x01, 0x11}},
+	)
+	commands, responses, ok = Parse(msg)
+	if !ok || len(commands) != 0 || len(responses) != 2 {
+		t.Fatalf("responses: %v %v %v", commands, responses, ok)
+	}
+	if got, _, ok := responses[0].Timecode(); !ok || got != tc || responses[0].Device != 3 {
+		t.Errorf("time code response: %v", responses[0])
+	}
+	if responses[1].Type != RespMotionControlTally || !bytes.Equal(responses[1].Data, []byte{0x01, 0x01, 0x11}) {
+		t.Errorf("tally response: %v", responses[1])
+	}
+
+	invalid := [][]byte{
+		{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7},
+		{0xF0, 0x7F, 0x7F, 0x01, 0x01, 0xF7},
+		{0xF0, 0x7F, 0x7F, 0x06, 0x44, 0x06, 0x01, 0xF7},
+		{0xF0, 0x7F, 0x7F, 0x07, 0x01, 0x00, 0xF7},
+		{0x90, 0x40, 0x7F},
+	}
+	for _, msg := range invalid {
+		if _, _, ok := Parse(msg); ok {
+			t.Errorf("% X parsed", msg)
+		}
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	var commands []Command
+	var responses []Response
+	r := newReceiver(Handler{
+		Command:  func(c Command) { commands = append(commands, c) },
+		Response: func(resp Response) { responses = append(responses, resp) },
+	}, Device(5))
+
+	r.Handle(Message(5, Command{Type: CmdStop}))
+	r.Handle(Message(6, Command{Type: CmdPlay}))
+	r.Handle(Message(AllDevices, Command{Type: CmdPause}))
+	r.Handle(ResponseMessage(5, TimecodeResponse(5, RespGeneratorTimeCode, mtc.Timecode{Rate: mtc.FPS24}, 0)))
+	r.Handle([]byte{0x90, 0x40, 0x7F})
+
+	if len(commands) != 2 || commands[0].Type != CmdStop || commands[1].Type != CmdPause {
+		t.Errorf("commands: %v", commands)
+	}
+	if len(responses) != 1 || responses[0].Type != RespGeneratorTimeCode {
+		t.Errorf("responses: %v", responses)
+	}
+}
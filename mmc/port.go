@@ -0,0 +1,161 @@
+package mmc
+
+import (
+	"github.com/gomidi/connect"
+	"github.com/minikomi/rtmididrv/mtc"
+)
+
+// Out is the part of an out port that the sender uses. It is implemented by rtmididrv.Out.
+type Out interface {
+	Send([]byte) error
+}
+
+// Sender sends MMC commands to a device.
+type Sender struct {
+	out    Out
+	device byte
+}
+
+// NewSender returns a sender of commands to the device with the given ID (AllDevices for all devices).
+func NewSender(out Out, device byte) *Sender {
+	return &Sender{out: out, device: device & 0x7F}
+}
+
+// Send sends one or more commands in one message.
+func (s *Sender) Send(commands ...Command) error {
+	return s.out.Send(Message(s.device, commands...))
+}
+
+func (s *Sender) send(typ CommandType) error {
+	return s.Send(Command{Type: typ})
+}
+
+// Stop sends stop.
+func (s *Sender) Stop() error { return s.send(CmdStop) }
+
+// Play sends play.
+func (s *Sender) Play() error { return s.send(CmdPlay) }
+
+// DeferredPlay sends deferred play: the device plays when a running locate has finished.
+func (s *Sender) DeferredPlay() error { return s.send(CmdDeferredPlay) }
+
+// FastForward sends fast forward.
+func (s *Sender) FastForward() error { return s.send(CmdFastForward) }
+
+// Rewind sends rewind.
+func (s *Sender) Rewind() error { return s.send(CmdRewind) }
+
+// RecordStrobe sends record strobe (punch in).
+func (s *Sender) RecordStrobe() error { return s.send(CmdRecordStrobe) }
+
+// RecordExit sends record exit (punch out).
+func (s *Sender) RecordExit() error { return s.send(CmdRecordExit) }
+
+// RecordPause sends record pause.
+func (s *Sender) RecordPause() error { return s.send(CmdRecordPause) }
+
+// Pause sends pause.
+func (s *Sender) Pause() error { return s.send(CmdPause) }
+
+// Eject sends eject.
+func (s *Sender) Eject() error { return s.send(CmdEject) }
+
+// Chase sends chase.
+func (s *Sender) Chase() error { return s.send(CmdChase) }
+
+// Reset sends MMC reset.
+func (s *Sender) Reset() error { return s.send(CmdReset) }
+
+// Locate sends a locate to the given timecode and subframe.
+func (s *Sender) Locate(t mtc.Timecode, subframe int) error {
+	return s.Send(Locate(s.device, t, subframe))
+}
+
+// Step sends a step command. Negative steps step backwards.
+func (s *Sender) Step(steps int) error {
+	return s.Send(Step(s.device, steps))
+}
+
+// Handler gets the commands and responses of a receiver. Nil functions are not called.
+type Handler struct {
+	Command  func(Command)
+	Response func(Response)
+}
+
+// Receiver parses the MMC messages that arrive at an in port and passes them to its handler.
+type Receiver struct {
+	in      connect.In
+	device  int
+	handler Handler
+}
+
+// ReceiverOption is an option for a receiver.
+type ReceiverOption func(*Receiver)
+
+// Device makes the receiver ignore messages for other devices than the one with the given ID.
+// Messages for all devices (AllDevices) are passed anyway. By default, the messages of all device IDs are passed.
+func Device(id byte) ReceiverOption {
+	return func(r *Receiver) {
+		r.device = int(id & 0x7F)
+	}
+}
+
+// NewReceiver returns a receiver of the MMC messages that arrive at the in port. The port is opened, if it is
+// not open, and the receiver sets its listener. To get SysEx messages, the driver must not ignore them
+// (see rtmididrv.IgnoreTypes).
+func NewReceiver(in connect.In, handler Handler, options ...ReceiverOption) (*Receiver, error) {
+	r := newReceiver(handler, options...)
+	r.in = in
+
+	if !in.IsOpen() {
+		err := in.Open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := in.SetListener(func(data []byte, _ int64) {
+		r.Handle(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func newReceiver(handler Handler, options ...ReceiverOption) *Receiver {
+	r := &Receiver{device: -1, handler: handler}
+	for _, opt := range options {
+		opt(r)
+	}
+	return r
+}
+
+// Handle parses a message and passes its commands or responses to the handler. Other messages are ignored.
+// It is called by the listener of the in port; call it to feed the receiver from other sources.
+func (r *Receiver) Handle(msg []byte) {
+	commands, responses, ok := Parse(msg)
+	if !ok {
+		return
+	}
+
+	if r.device >= 0 && int(msg[2]) != r.device && msg[2] != AllDevices {
+		return
+	}
+
+	if r.handler.Command != nil {
+		for _, c := range commands {
+			r.handler.Command(c)
+		}
+	}
+	if r.handler.Response != nil {
+		for _, resp := range responses {
+			r.handler.Response(resp)
+		}
+	}
+}
+
+// Close stops listening to the in port. The port is not closed.
+func (r *Receiver) Close() error {
+	return r.in.StopListening()
+}
@@ -0,0 +1,129 @@
+package mmc
+
+import (
+	"fmt"
+
+	"github.com/minikomi/rtmididrv/mtc"
+)
+
+// ResponseType is the code of an MMC response (an information field).
+type ResponseType byte
+
+// Some of the MMC responses.
+const (
+	RespSelectedTimeCode   ResponseType = 0x01
+	RespGeneratorTimeCode  ResponseType = 0x04
+	RespMTCInput           ResponseType = 0x05
+	RespMotionControlTally ResponseType = 0x4D
+	RespRecordStatus       ResponseType = 0x4E
+)
+
+var responseNames = map[ResponseType]string{
+	RespSelectedTimeCode:   "selected_time_code",
+	RespGeneratorTimeCode:  "generator_time_code",
+	RespMTCInput:           "mtc_input",
+	RespMotionControlTally: "motion_control_tally",
+	RespRecordStatus:       "record_status",
+}
+
+func (r ResponseType) String() string {
+	if name, has := responseNames[r]; has {
+		return name
+	}
+	return fmt.Sprintf("response_%02X", byte(r))
+}
+
+// Response is an MMC response.
+type Response struct {
+	// Device is the device ID of the message.
+	Device byte
+	Type   ResponseType
+	// Data are the data bytes of the response: the standard time code of responses from 01 to 1F,
+	// the short time code of responses from 21 to 3F and the data without the count of responses from 40 to 77.
+	Data []byte
+}
+
+func (r Response) String() string {
+	if t, sub, ok := r.Timecode(); ok {
+		return fmt.Sprintf("%v device=%v time=%v.%02d", r.Type, r.Device, t, sub)
+	}
+	return fmt.Sprintf("%v device=%v data=% X", r.Type, r.Device, r.Data)
+}
+
+// Timecode returns the time of a response with a standard time code (01 to 1F).
+func (r Response) Timecode() (t mtc.Timecode, subframe int, ok bool) {
+	if r.Type < 0x01 || r.Type > 0x1F || len(r.Data) != 5 {
+		return mtc.Timecode{}, 0, false
+	}
+	return parseTimecode(r.Data)
+}
+
+// TimecodeResponse returns a response with the given time code for the responses from 01 to 1F.
+func TimecodeResponse(device byte, typ ResponseType, t mtc.Timecode, subframe int) Response {
+	return Response{Device: device, Type: typ, Data: timecodeBytes(t, subframe)}
+}
+
+// ResponseMessage returns a SysEx message with one or more responses of the given device.
+// The device IDs of the responses are ignored.
+func ResponseMessage(device byte, responses ...Response) []byte {
+	msg := []byte{0xF0, 0x7F, device & 0x7F, subIDResponse}
+	for _, r := range responses {
+		msg = append(msg, byte(r.Type))
+		if hasData(byte(r.Type)) {
+			msg = append(msg, byte(len(r.Data)))
+		}
+		msg = append(msg, r.Data...)
+	}
+	return append(msg, 0xF7)
+}
+
+// Parse parses an MMC message. It returns the commands of a command message or the responses of a response message.
+// ok is false, if the message is no MMC message or malformed.
+func Parse(msg []byte) (commands []Command, responses []Response, ok bool) {
+	if len(msg) < 6 || msg[0] != 0xF0 || msg[1] != 0x7F || msg[len(msg)-1] != 0xF7 {
+		return nil, nil, false
+	}
+
+	device := msg[2]
+	sub := msg[3]
+	if sub != subIDCommand && sub != subIDResponse {
+		return nil, nil, false
+	}
+
+	data := msg[4 : len(msg)-1]
+	for len(data) > 0 {
+		code := data[0]
+		data = data[1:]
+
+		var n int
+		switch {
+		case code == 0x00 || code >= 0x78:
+			// extensions and reserved codes
+			return nil, nil, false
+		case hasData(code):
+			if len(data) == 0 {
+				return nil, nil, false
+			}
+			n = int(data[0])
+			data = data[1:]
+		case sub == subIDResponse && code <= 0x1F:
+			n = 5
+		case sub == subIDResponse:
+			n = 2
+		}
+
+		if n > len(data) {
+			return nil, nil, false
+		}
+		payload := append([]byte(nil), data[:n]...)
+		data = data[n:]
+
+		if sub == subIDCommand {
+			commands = append(commands, Command{Device: device, Type: CommandType(code), Data: payload})
+		} else {
+			responses = append(responses, Response{Device: device, Type: ResponseType(code), Data: payload})
+		}
+	}
+
+	return commands, responses, true
+}
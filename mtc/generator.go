@@ -0,0 +1,179 @@
+package mtc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Out is the part of an out port that the generator uses. It is implemented by rtmididrv.Out.
+type Out interface {
+	Send([]byte) error
+}
+
+// Generator sends MIDI Time Code to an out port. While it is running, it sends four quarter frame messages
+// per frame; eight of them make up the timecode of the frame at which the first of them has been sent.
+//
+// Every quarter frame is scheduled at its absolute time since the start, so that delays of single
+// messages do not add up.
+type Generator struct {
+	out  Out
+	rate Rate
+
+	mx           sync.Mutex
+	errorHandler func(error)
+	// position is the frame at which the generator has been started or located
+	position int64
+	running  bool
+	start    time.Time
+	// stop is nil while the generator is stopping
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewGenerator returns a stopped generator at 00:00:00:00 with the given frame rate.
+func NewGenerator(out Out, rate Rate) (*Generator, error) {
+	if rate > FPS30 {
+		return nil, fmt.Errorf("invalid frame rate %v", rate)
+	}
+	return &Generator{out: out, rate: rate}, nil
+}
+
+// SetErrorHandler sets a function that is called with the errors of sending messages.
+func (g *Generator) SetErrorHandler(handler func(error)) {
+	g.mx.Lock()
+	g.errorHandler = handler
+	g.mx.Unlock()
+}
+
+// Timecode returns the current timecode.
+func (g *Generator) Timecode() Timecode {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return FromFrame(g.frame(time.Now()), g.rate)
+}
+
+// frame returns the frame at the given time. It must be called with the lock held.
+func (g *Generator) frame(now time.Time) int64 {
+	if !g.running {
+		return g.position
+	}
+	return g.position + int64(now.Sub(g.start).Seconds()*g.rate.fps())
+}
+
+// Running reports whether the generator is running.
+func (g *Generator) Running() bool {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return g.running
+}
+
+// Locate sets the position and sends it as full frame message. The generator must be stopped.
+func (g *Generator) Locate(t Timecode) error {
+	if t.Rate != g.rate || !t.Valid() {
+		return fmt.Errorf("invalid timecode %v at %v fps", t, g.rate)
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.running {
+		return fmt.Errorf("can't locate: timecode is running")
+	}
+
+	g.position = t.Frame()
+	g.send(FullFrame(t))
+	return nil
+}
+
+// Start starts sending quarter frames at the current position. It does nothing, if the generator is running.
+func (g *Generator) Start() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.running {
+		return
+	}
+
+	g.running = true
+	g.start = time.Now()
+	g.stop = make(chan struct{})
+	g.done = make(chan struct{})
+	go g.run(g.start, g.position, g.stop, g.done)
+}
+
+// Stop stops sending quarter frames and keeps the current position.
+func (g *Generator) Stop() {
+	g.mx.Lock()
+	if !g.running {
+		g.mx.Unlock()
+		return
+	}
+	done := g.done
+	if g.stop == nil {
+		// another call is stopping the generator
+		g.mx.Unlock()
+		<-done
+		return
+	}
+	close(g.stop)
+	g.stop = nil
+	g.mx.Unlock()
+
+	<-done
+
+	g.mx.Lock()
+	g.position = g.frame(time.Now())
+	g.running = false
+	g.mx.Unlock()
+}
+
+func (g *Generator) run(start time.Time, position int64, stop, done chan struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	// a quarter frame is sent every quarter of a frame
+	quarter := float64(time.Second) / g.rate.fps() / 4
+
+	var tc Timecode
+	for k := int64(0); ; k++ {
+		piece := int(k % 8)
+		if piece == 0 {
+			// the eight pieces carry the timecode of the frame at which the first one is sent
+			tc = FromFrame(position+k/4, g.rate)
+		}
+
+		target := start.Add(time.Duration(float64(k) * quarter))
+		if d := time.Until(target); d > 0 {
+			timer.Reset(d)
+			select {
+			case <-stop:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				return
+			case <-timer.C:
+			}
+		}
+
+		g.mx.Lock()
+		select {
+		case <-stop:
+			g.mx.Unlock()
+			return
+		default:
+		}
+		g.send(QuarterFrame(tc, piece))
+		g.mx.Unlock()
+	}
+}
+
+// send sends the message. It must be called with the lock held.
+func (g *Generator) send(msg []byte) {
+	err := g.out.Send(msg)
+	if err != nil && g.errorHandler != nil {
+		g.errorHandler(fmt.Errorf("can't send % X: %v", msg, err))
+	}
+}
@@ -0,0 +1,148 @@
+package mtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// readerOut passes the messages of a generator to a reader.
+type readerOut struct {
+	sync.Mutex
+	r    *Reader
+	msgs [][]byte
+}
+
+func (o *readerOut) Send(data []byte) error {
+	o.Lock()
+	o.msgs = append(o.msgs, append([]byte(nil), data...))
+	o.Unlock()
+	o.r.Handle(data)
+	return nil
+}
+
+func TestGenerator(t *testing.T) {
+	r := newReader(Timeout(time.Hour))
+	defer r.Close()
+
+	out := &readerOut{r: r}
+	g, err := NewGenerator(out, FPS25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := Timecode{1, 2, 3, 4, FPS25}
+	err = g.Locate(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Timecode() != start || r.Locked() {
+		t.Errorf("after locate: %v, locked %v", r.Timecode(), r.Locked())
+	}
+
+	g.Start()
+	if err := g.Locate(start); err == nil {
+		t.Errorf("expected error when locating while running")
+	}
+
+	// 25 fps: a quarter frame every 10ms
+	time.Sleep(250 * time.Millisecond)
+	g.Stop()
+
+	if !r.Locked() || r.Direction() != Forward {
+		t.Fatalf("reader not locked")
+	}
+
+	got := r.Timecode().Frame() - start.Frame()
+	if got < 4 || got > 8 {
+		t.Errorf("reader at %v after 250ms, started at %v", r.Timecode(), start)
+	}
+
+	if d := g.Timecode().Frame() - start.Frame(); d < 6 || d > 10 {
+		t.Errorf("generator at %v after 250ms", g.Timecode())
+	}
+
+	out.Lock()
+	n := len(out.msgs)
+	out.Unlock()
+	if n < 16 {
+		t.Errorf("%v messages", n)
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	r := newReader(Timeout(time.Hour))
+	defer r.Close()
+
+	g, err := NewGenerator(&readerOut{r: r}, FPS25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Start()
+	time.Sleep(20 * time.Millisecond)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			g.Stop()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if g.Running() {
+		t.Errorf("still running")
+	}
+}
+
+func TestReader(t *testing.T) {
+	r := newReader(Timeout(30 * time.Millisecond))
+	defer r.Close()
+
+	tc := Timecode{10, 0, 0, 10, FPS30}
+	send := func(tc Timecode, pieces ...int) {
+		for _, p := range pieces {
+			r.Handle(QuarterFrame(tc, p))
+		}
+	}
+
+	send(tc, 4, 5, 6, 7)
+	if r.Locked() {
+		t.Errorf("locked after half a sequence")
+	}
+
+	send(tc, 0, 1, 2, 3, 4, 5, 6, 7)
+	if !r.Locked() || r.Timecode() != tc.Add(2) {
+		t.Errorf("forward: %v, locked %v", r.Timecode(), r.Locked())
+	}
+
+	send(tc.Add(2), 0, 1, 2, 3)
+	if r.Timecode() != tc.Add(3) {
+		t.Errorf("after 4 quarter frames: %v", r.Timecode())
+	}
+
+	// dropout
+	send(tc.Add(2), 5)
+	if r.Locked() {
+		t.Errorf("locked after a missing quarter frame")
+	}
+
+	// reverse
+	send(tc, 7, 6, 5, 4, 3, 2, 1, 0)
+	if !r.Locked() || r.Direction() != Reverse || r.Timecode() != tc.Add(-2) {
+		t.Errorf("reverse: %v, locked %v, %v", r.Timecode(), r.Locked(), r.Direction())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Locked() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r.Locked() {
+		t.Errorf("still locked after the timeout")
+	}
+}
@@ -0,0 +1,240 @@
+package mtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+)
+
+// Direction is the direction of the timecode that a reader gets.
+type Direction int
+
+const (
+	// Forward is the direction of quarter frames with increasing pieces.
+	Forward Direction = iota
+	// Reverse is the direction of quarter frames with decreasing pieces, e.g. when a tape is rewound.
+	Reverse
+)
+
+func (d Direction) String() string {
+	if d == Reverse {
+		return "reverse"
+	}
+	return "forward"
+}
+
+// Reader reads MIDI Time Code from an in port.
+//
+// The reader assembles the timecode from eight consecutive quarter frames in either direction. As these
+// carry the timecode of the frame at which the first of them was sent, the current timecode is two frames
+// later (or earlier, in reverse) when the last of them arrives; in between the reader counts one frame per
+// four quarter frames. The reader is locked from the first complete sequence of quarter frames until a
+// quarter frame is missing or the timeout passes without quarter frames. Full frame messages set the
+// timecode without locking the reader.
+type Reader struct {
+	in connect.In
+
+	mx      sync.Mutex
+	timeout time.Duration
+
+	pieces    [8]int
+	count     int
+	lastPiece int
+	direction Direction
+
+	timecode Timecode
+	// base is the frame of the last complete sequence of quarter frames
+	base   int64
+	locked bool
+
+	timer    *time.Timer
+	lastWall time.Time
+	closed   bool
+}
+
+// ReaderOption is an option for a reader.
+type ReaderOption func(*Reader)
+
+// Timeout sets the time without quarter frames after which the reader is no longer locked.
+// The default is 100ms.
+func Timeout(d time.Duration) ReaderOption {
+	return func(r *Reader) {
+		r.timeout = d
+	}
+}
+
+// NewReader returns a reader of the timecode that arrives at the in port. The port is opened, if it is not open,
+// and the reader sets its listener. To get quarter frames, the driver must not ignore timing messages
+// (see rtmididrv.IgnoreTypes).
+func NewReader(in connect.In, options ...ReaderOption) (*Reader, error) {
+	r := newReader(options...)
+	r.in = in
+
+	if !in.IsOpen() {
+		err := in.Open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := in.SetListener(func(data []byte, _ int64) {
+		r.Handle(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func newReader(options ...ReaderOption) *Reader {
+	r := &Reader{timeout: 100 * time.Millisecond, lastPiece: -1}
+	for _, opt := range options {
+		opt(r)
+	}
+	return r
+}
+
+// Handle processes a message. It is called by the listener of the in port; call it to feed the reader
+// from other sources.
+func (r *Reader) Handle(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	if msg[0] == 0xF1 && len(msg) == 2 {
+		r.quarterFrame(int(msg[1]>>4&0x07), int(msg[1]&0x0F))
+		return
+	}
+
+	if t, ok := ParseFullFrame(msg); ok {
+		r.timecode = t
+		r.locked = false
+		r.count = 0
+		r.lastPiece = -1
+	}
+}
+
+// quarterFrame handles a quarter frame. It must be called with the lock held.
+func (r *Reader) quarterFrame(piece, value int) {
+	r.lastWall = time.Now()
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.timeout, r.checkTimeout)
+	} else {
+		r.timer.Reset(r.timeout)
+	}
+
+	switch {
+	case r.lastPiece < 0:
+		r.count = 0
+	case piece == (r.lastPiece+1)%8:
+		if r.direction != Forward {
+			// the previous quarter frame is the first one in the new direction
+			r.direction = Forward
+			r.count = 1
+		}
+	case piece == (r.lastPiece+7)%8:
+		if r.direction != Reverse {
+			// the previous quarter frame is the first one in the new direction
+			r.direction = Reverse
+			r.count = 1
+		}
+	default:
+		// a quarter frame is missing
+		r.count = 0
+		r.locked = false
+	}
+
+	r.lastPiece = piece
+	r.pieces[piece] = value
+	if r.count < 8 {
+		r.count++
+	}
+
+	if r.count == 8 && (r.direction == Forward && piece == 7 || r.direction == Reverse && piece == 0) {
+		t := Timecode{
+			Frames:  r.pieces[0] | r.pieces[1]&0x01<<4,
+			Seconds: r.pieces[2] | r.pieces[3]&0x03<<4,
+			Minutes: r.pieces[4] | r.pieces[5]&0x03<<4,
+			Hours:   r.pieces[6] | r.pieces[7]&0x01<<4,
+			Rate:    Rate(r.pieces[7] >> 1 & 0x03),
+		}
+		if !t.Valid() {
+			r.locked = false
+			return
+		}
+
+		r.base = t.Frame() + 2
+		if r.direction == Reverse {
+			r.base = t.Frame() - 2
+		}
+		r.timecode = FromFrame(r.base, t.Rate)
+		r.locked = true
+		return
+	}
+
+	// half way through the next sequence
+	if r.locked && (r.direction == Forward && piece == 3 || r.direction == Reverse && piece == 4) {
+		if r.direction == Forward {
+			r.timecode = FromFrame(r.base+1, r.timecode.Rate)
+		} else {
+			r.timecode = FromFrame(r.base-1, r.timecode.Rate)
+		}
+	}
+}
+
+// checkTimeout is called by the timer when no quarter frame has arrived within the timeout.
+func (r *Reader) checkTimeout() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if time.Since(r.lastWall) < r.timeout {
+		return
+	}
+	r.locked = false
+	r.count = 0
+	r.lastPiece = -1
+}
+
+// Timecode returns the current timecode.
+func (r *Reader) Timecode() Timecode {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.timecode
+}
+
+// Locked reports whether the reader gets complete sequences of quarter frames.
+func (r *Reader) Locked() bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.locked
+}
+
+// Direction returns the direction of the last quarter frames.
+func (r *Reader) Direction() Direction {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.direction
+}
+
+// Close stops listening to the in port. The port is not closed.
+func (r *Reader) Close() error {
+	r.mx.Lock()
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mx.Unlock()
+
+	if r.in == nil {
+		return nil
+	}
+	return r.in.StopListening()
+}
@@ -0,0 +1,186 @@
+// Package mtc sends and reads MIDI Time Code: quarter frame messages while running and full frame
+// SysEx messages for locating.
+package mtc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rate is the frame rate of a timecode. Its value is the rate code of MIDI Time Code.
+type Rate byte
+
+const (
+	// FPS24 is 24 frames per second (film).
+	FPS24 Rate = iota
+	// FPS25 is 25 frames per second (PAL).
+	FPS25
+	// FPS2997DF is 29.97 frames per second with drop frame counting (NTSC).
+	FPS2997DF
+	// FPS30 is 30 frames per second.
+	FPS30
+)
+
+func (r Rate) String() string {
+	switch r {
+	case FPS24:
+		return "24"
+	case FPS25:
+		return "25"
+	case FPS2997DF:
+		return "29.97df"
+	case FPS30:
+		return "30"
+	}
+	return fmt.Sprintf("Rate(%d)", byte(r))
+}
+
+// nominal returns the number of frames in a second of the timecode.
+func (r Rate) nominal() int64 {
+	switch r {
+	case FPS24:
+		return 24
+	case FPS25:
+		return 25
+	}
+	return 30
+}
+
+// fps returns the real number of frames per second.
+func (r Rate) fps() float64 {
+	if r == FPS2997DF {
+		return 30000.0 / 1001
+	}
+	return float64(r.nominal())
+}
+
+// Timecode is a SMPTE timecode.
+type Timecode struct {
+	Hours, Minutes, Seconds, Frames int
+	Rate                            Rate
+}
+
+// String returns the timecode as HH:MM:SS:FF, with a semicolon before the frames for drop frame timecode.
+func (t Timecode) String() string {
+	sep := ":"
+	if t.Rate == FPS2997DF {
+		sep = ";"
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%02d", t.Hours, t.Minutes, t.Seconds, sep, t.Frames)
+}
+
+// Valid reports whether the timecode exists: all fields are in range and, for drop frame timecode,
+// the frame is not one of the dropped ones.
+func (t Timecode) Valid() bool {
+	if t.Rate > FPS30 || t.Hours < 0 || t.Hours > 23 || t.Minutes < 0 || t.Minutes > 59 ||
+		t.Seconds < 0 || t.Seconds > 59 || t.Frames < 0 || int64(t.Frames) >= t.Rate.nominal() {
+		return false
+	}
+	return t.Rate != FPS2997DF || t.Seconds != 0 || t.Frames > 1 || t.Minutes%10 == 0
+}
+
+// Frame returns the number of the frame since 00:00:00:00.
+func (t Timecode) Frame() int64 {
+	fps := t.Rate.nominal()
+	minutes := int64(t.Hours)*60 + int64(t.Minutes)
+	n := (minutes*60+int64(t.Seconds))*fps + int64(t.Frames)
+	if t.Rate == FPS2997DF {
+		// two frame numbers are dropped every minute, except every tenth minute
+		n -= 2 * (minutes - minutes/10)
+	}
+	return n
+}
+
+// Duration returns the real time since 00:00:00:00.
+func (t Timecode) Duration() time.Duration {
+	return time.Duration(float64(t.Frame()) / t.Rate.fps() * float64(time.Second))
+}
+
+// Add returns the timecode the given number of frames later (or earlier, if frames is negative).
+// The timecode wraps around at 24 hours.
+func (t Timecode) Add(frames int64) Timecode {
+	return FromFrame(t.Frame()+frames, t.Rate)
+}
+
+// framesPerDay returns the number of frames in 24 hours.
+func (r Rate) framesPerDay() int64 {
+	return Timecode{Hours: 24, Rate: r}.Frame()
+}
+
+// FromFrame returns the timecode of the frame with the given number since 00:00:00:00.
+func FromFrame(n int64, r Rate) Timecode {
+	day := r.framesPerDay()
+	n %= day
+	if n < 0 {
+		n += day
+	}
+
+	if r == FPS2997DF {
+		// add the dropped frame numbers
+		const framesPer10Minutes, framesPerMinute = 17982, 1798
+		d, m := n/framesPer10Minutes, n%framesPer10Minutes
+		n += 18 * d
+		if m > 1 {
+			n += 2 * ((m - 2) / framesPerMinute)
+		}
+	}
+
+	fps := r.nominal()
+	return Timecode{
+		Hours:   int(n / (fps * 3600)),
+		Minutes: int(n / (fps * 60) % 60),
+		Seconds: int(n / fps % 60),
+		Frames:  int(n % fps),
+		Rate:    r,
+	}
+}
+
+// FromDuration returns the timecode of the frame at the given real time since 00:00:00:00.
+func FromDuration(d time.Duration, r Rate) Timecode {
+	return FromFrame(int64(d.Seconds()*r.fps()), r)
+}
+
+// FullFrame returns the full frame SysEx message of the timecode.
+func FullFrame(t Timecode) []byte {
+	return []byte{0xF0, 0x7F, 0x7F, 0x01, 0x01,
+		byte(t.Rate)<<5 | byte(t.Hours), byte(t.Minutes), byte(t.Seconds), byte(t.Frames), 0xF7}
+}
+
+// ParseFullFrame returns the timecode of a full frame SysEx message.
+func ParseFullFrame(msg []byte) (Timecode, bool) {
+	if len(msg) != 10 || msg[0] != 0xF0 || msg[1] != 0x7F || msg[3] != 0x01 || msg[4] != 0x01 || msg[9] != 0xF7 {
+		return Timecode{}, false
+	}
+	t := Timecode{
+		Hours:   int(msg[5] & 0x1F),
+		Minutes: int(msg[6]),
+		Seconds: int(msg[7]),
+		Frames:  int(msg[8]),
+		Rate:    Rate(msg[5] >> 5 & 0x03),
+	}
+	return t, t.Valid()
+}
+
+// QuarterFrame returns the quarter frame message with the given piece (0-7) of the timecode.
+func QuarterFrame(t Timecode, piece int) []byte {
+	var v int
+	switch piece {
+	case 0:
+		v = t.Frames & 0x0F
+	case 1:
+		v = t.Frames >> 4 & 0x01
+	case 2:
+		v = t.Seconds & 0x0F
+	case 3:
+		v = t.Seconds >> 4 & 0x03
+	case 4:
+		v = t.Minutes & 0x0F
+	case 5:
+		v = t.Minutes >> 4 & 0x03
+	case 6:
+		v = t.Hours & 0x0F
+	case 7:
+		v = t.Hours>>4&0x01 | int(t.Rate)<<1
+	}
+	return []byte{0xF1, byte(piece<<4 | v)}
+}
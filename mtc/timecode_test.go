@@ -0,0 +1,83 @@
+package mtc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		tc    Timecode
+		frame int64
+		str   string
+	}{
+		{Timecode{0, 0, 1, 0, FPS24}, 24, "00:00:01:00"},
+		{Timecode{1, 0, 0, 0, FPS25}, 90000, "01:00:00:00"},
+		{Timecode{0, 1, 0, 0, FPS30}, 1800, "00:01:00:00"},
+		{Timecode{0, 0, 59, 29, FPS2997DF}, 1799, "00:00:59;29"},
+		{Timecode{0, 1, 0, 2, FPS2997DF}, 1800, "00:01:00;02"},
+		{Timecode{0, 10, 0, 0, FPS2997DF}, 17982, "00:10:00;00"},
+		{Timecode{0, 10, 0, 1, FPS2997DF}, 17983, "00:10:00;01"},
+		{Timecode{1, 0, 0, 0, FPS2997DF}, 107892, "01:00:00;00"},
+		{Timecode{23, 59, 59, 29, FPS2997DF}, 2589407, "23:59:59;29"},
+	}
+
+	for _, test := range tests {
+		if got := test.tc.Frame(); got != test.frame {
+			t.Errorf("%v: frame %v, want %v", test.tc, got, test.frame)
+		}
+		if got := FromFrame(test.frame, test.tc.Rate); got != test.tc {
+			t.Errorf("FromFrame(%v): got %v, want %v", test.frame, got, test.tc)
+		}
+		if got := test.tc.String(); got != test.str {
+			t.Errorf("got %q, want %q", got, test.str)
+		}
+		if !test.tc.Valid() {
+			t.Errorf("%v is not valid", test.tc)
+		}
+	}
+
+	// the dropped frames don't exist
+	if (Timecode{0, 1, 0, 0, FPS2997DF}).Valid() || (Timecode{0, 0, 0, 25, FPS25}).Valid() {
+		t.Errorf("invalid timecode is valid")
+	}
+
+	// wrap around at 24 hours
+	if got := (Timecode{23, 59, 59, 29, FPS2997DF}).Add(1); got != (Timecode{Rate: FPS2997DF}) {
+		t.Errorf("got %v after the last frame", got)
+	}
+	if got := (Timecode{Rate: FPS25}).Add(-1); got != (Timecode{23, 59, 59, 24, FPS25}) {
+		t.Errorf("got %v before the first frame", got)
+	}
+
+	// an hour of drop frame timecode takes 3600 real seconds minus 3.6 frames
+	d := (Timecode{1, 0, 0, 0, FPS2997DF}).Duration()
+	if want := time.Hour; d < want-200*time.Millisecond || d > want {
+		t.Errorf("duration %v", d)
+	}
+	if got := FromDuration(time.Hour, FPS25); got != (Timecode{1, 0, 0, 0, FPS25}) {
+		t.Errorf("FromDuration: got %v", got)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	tc := Timecode{17, 42, 33, 28, FPS2997DF}
+
+	msg := FullFrame(tc)
+	got, ok := ParseFullFrame(msg)
+	if !ok || got != tc {
+		t.Errorf("full frame % X: got %v", msg, got)
+	}
+
+	if _, ok := ParseFullFrame([]byte{0xF0, 0x7E, 0x7F, 0x01, 0x01, 0, 0, 0, 0, 0xF7}); ok {
+		t.Errorf("non-realtime SysEx parsed as full frame")
+	}
+
+	want := []byte{0x0C, 0x11, 0x21, 0x32, 0x4A, 0x52, 0x61, 0x75}
+	for piece, b := range want {
+		msg := QuarterFrame(tc, piece)
+		if msg[0] != 0xF1 || msg[1] != b {
+			t.Errorf("piece %v: got % X, want F1 %02X", piece, msg, b)
+		}
+	}
+}
@@ -1,35 +1,38 @@
 package rtmididrv
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/gomidi/connect"
-	"github.com/minikomi/rtmididrv/imported/rtmidi"
 	//	"github.com/metakeule/mutex"
 )
 
-func newOut(debug bool, driver *driver, number int, name string) connect.Out {
+func newOut(debug bool, driver *Driver, number int, name string) Out {
 	o := &out{driver: driver, number: number, name: name}
+	// the handle gets the shared connection when it is opened
+	o.conn = &outConn{driver: driver, key: portKey{number, name}}
 	//	o.RWMutex = mutex.NewRWMutex("rtmididrv out port "+name, debug)
 	return o
 }
 
+// out is a handle to a MIDI out port. All open handles of the same port share one native connection.
 type out struct {
-	driver  *driver
-	midiOut rtmidi.MIDIOut
-	number  int
-	name    string
+	driver *Driver
+	conn   *outConn
+	number int
+	name   string
 	sync.RWMutex
 	//	mutex.RWMutex
-	closed bool
+	open bool
 }
 
 // IsOpen returns wether the port is open
 func (o *out) IsOpen() (open bool) {
 	o.RLock()
-	open = !o.closed && o.midiOut != nil
+	open = o.open
 	o.RUnlock()
 	return
 }
@@ -37,16 +40,13 @@ func (o *out) IsOpen() (open bool) {
 // Send sends a message to the MIDI out port
 // If the out port is closed, it returns connect.ErrClosed
 func (o *out) Send(b []byte) error {
-	//o.RLock()
-	o.Lock()
-	defer o.Unlock()
-	if o.closed || o.midiOut == nil {
-		//o.RUnlock()
+	o.RLock()
+	defer o.RUnlock()
+	if !o.open {
 		return connect.ErrClosed
 	}
-	//	o.RUnlock()
 
-	err := o.midiOut.SendMessage(b)
+	err := o.conn.send(b)
 	if err != nil {
 		return fmt.Errorf("could not send message to MIDI out %v (%s): %v", o.number, o, err)
 	}
@@ -55,8 +55,22 @@ func (o *out) Send(b []byte) error {
 
 // Underlying returns the underlying rtmidi.MIDIOut. Use it with type casting:
 //   rtOut := o.Underlying().(rtmidi.MIDIOut)
+// The rtmidi.MIDIOut is shared by all open handles of the port.
 func (o *out) Underlying() interface{} {
-	return o.midiOut
+	c := o.connection()
+	c.Lock()
+	defer c.Unlock()
+	if c.midiOut == nil {
+		return nil
+	}
+	return c.midiOut
+}
+
+// connection returns the connection of the handle. It is replaced whenever the handle is opened.
+func (o *out) connection() *outConn {
+	o.RLock()
+	defer o.RUnlock()
+	return o.conn
 }
 
 // Number returns the number of the MIDI out port.
@@ -71,56 +85,86 @@ func (o *out) String() string {
 	return o.name
 }
 
+// Handle returns a new handle to the same MIDI out port. The new handle is not open.
+// Every handle is opened and closed on its own, while the native port is shared by all open handles
+// and released when the last of them is closed.
+func (o *out) Handle() Out {
+	return newOut(o.driver.debug, o.driver, o.number, o.name)
+}
+
+// SendActiveSensing lets the port send active sensing whenever nothing has been sent for the interval,
+// so that receivers that supervise active sensing keep the connection. DefaultActiveSensingInterval
+// suits the timeout of the MIDI specification. An interval <= 0 stops sending active sensing.
+// The setting is shared by all handles of the port and ends when the port is closed.
+func (o *out) SendActiveSensing(interval time.Duration) error {
+	o.RLock()
+	defer o.RUnlock()
+	if !o.open {
+		return connect.ErrClosed
+	}
+	o.conn.setSensing(interval)
+	return nil
+}
+
+// Stats returns the traffic counters of the port. They are shared by all handles of the port.
+func (o *out) Stats() Stats {
+	c := o.connection()
+	return c.stats.snapshot("out", c.key)
+}
+
 // Close closes the MIDI out port
 func (o *out) Close() error {
-	o.RLock()
-	if o.closed || o.midiOut == nil {
-		o.RUnlock()
+	return o.CloseContext(context.Background())
+}
+
+// CloseContext closes the MIDI out port.
+// If ctx is done before the port could be closed, an error wrapping ctx.Err() is returned.
+// The port counts as closed anyway and the native port is released in the background.
+// A closed port may be opened again.
+func (o *out) CloseContext(ctx context.Context) error {
+	o.Lock()
+	if !o.open {
+		o.Unlock()
 		return nil
 	}
-	o.RUnlock()
-	o.Lock()
-	o.closed = true
+	o.open = false
+	c := o.conn
 	o.Unlock()
 
-	// disabling closing of the out port. it does not work reliably in a context with multiple goroutines
-	// last try for closing
-	time.Sleep(time.Millisecond * 500)
-	//	o.Lock()
-	err := o.midiOut.Close()
-	//	o.midiOut.Destroy()
-	//	o.Unlock()
+	o.driver.unregister(o)
 
+	err := c.release(ctx)
+	o.driver.pruneOutConn(c)
 	if err != nil {
-		return fmt.Errorf("can't close MIDI out %v (%s): %v", o.number, o, err)
+		return fmt.Errorf("can't close MIDI out %v (%s): %w", o.number, o, err)
 	}
 
 	return nil
 }
 
 // Open opens the MIDI out port
-func (o *out) Open() (err error) {
-	o.RLock()
-	if o.closed || o.midiOut != nil {
-		o.RUnlock()
-		return nil
-	}
-	o.RUnlock()
+func (o *out) Open() error {
+	return o.OpenContext(context.Background())
+}
+
+// OpenContext opens the MIDI out port. If ctx is done before the port could be opened,
+// ctx.Err() is returned and the port is closed again as soon as the pending native call returns.
+func (o *out) OpenContext(ctx context.Context) (err error) {
 	o.Lock()
 	defer o.Unlock()
-	o.midiOut, err = rtmidi.NewMIDIOutDefault()
-	if err != nil {
-		return fmt.Errorf("can't open default MIDI out: %v", err)
+
+	if o.open {
+		return nil
 	}
 
-	err = o.midiOut.OpenPort(o.number, "")
+	c, err := o.driver.acquireOutConn(ctx, o.number, o.name)
 	if err != nil {
-		return fmt.Errorf("can't open MIDI out port %v (%s): %v", o.number, o, err)
+		return err
 	}
 
-	o.driver.Lock()
-	o.driver.opened = append(o.driver.opened, o)
-	o.driver.Unlock()
+	o.conn = c
+	o.open = true
+	o.driver.register(o)
 
 	return nil
 }
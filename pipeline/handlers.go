@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"math"
+
+	"github.com/minikomi/rtmididrv"
+)
+
+// isChannelMessage reports whether msg is a channel message.
+func isChannelMessage(msg []byte) bool {
+	return msg[0] >= 0x80 && msg[0] < 0xF0
+}
+
+// isNote reports whether msg is a note on, note off or polyphonic aftertouch message.
+func isNote(msg []byte) bool {
+	if len(msg) != 3 {
+		return false
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90, 0xA0:
+		return true
+	}
+	return false
+}
+
+func clone(msg []byte) []byte {
+	return append([]byte(nil), msg...)
+}
+
+// Channels passes the channel messages of the given channels (1-16) and drops those of the other channels.
+// System messages pass.
+func Channels(channels ...int) Handler {
+	var pass [16]bool
+	for _, ch := range channels {
+		if ch >= 1 && ch <= 16 {
+			pass[ch-1] = true
+		}
+	}
+
+	return func(msg []byte, next func([]byte)) {
+		if !isChannelMessage(msg) || pass[msg[0]&0x0F] {
+			next(msg)
+		}
+	}
+}
+
+// Types passes the messages of the given types and drops the others.
+func Types(types ...rtmididrv.StatusType) Handler {
+	pass := map[rtmididrv.StatusType]bool{}
+	for _, t := range types {
+		pass[t] = true
+	}
+
+	return func(msg []byte, next func([]byte)) {
+		if pass[rtmididrv.StatusTypeOf(msg)] {
+			next(msg)
+		}
+	}
+}
+
+// Transpose transposes note on, note off and polyphonic aftertouch messages by the given semitones.
+// Notes that are out of range after transposing are dropped.
+func Transpose(semitones int) Handler {
+	return func(msg []byte, next func([]byte)) {
+		if !isNote(msg) || semitones == 0 {
+			next(msg)
+			return
+		}
+
+		key := int(msg[1]) + semitones
+		if key < 0 || key > 127 {
+			return
+		}
+
+		out := clone(msg)
+		out[1] = byte(key)
+		next(out)
+	}
+}
+
+// VelocityCurve maps the velocity of note on messages with the given curve. Note ons keep a velocity of at least 1,
+// so that they do not turn into note offs. Results above 127 are limited to 127.
+func VelocityCurve(curve func(velocity uint8) uint8) Handler {
+	return func(msg []byte, next func([]byte)) {
+		if len(msg) != 3 || msg[0]&0xF0 != 0x90 || msg[2] == 0 {
+			next(msg)
+			return
+		}
+
+		vel := curve(msg[2])
+		switch {
+		case vel < 1:
+			vel = 1
+		case vel > 127:
+			vel = 127
+		}
+
+		out := clone(msg)
+		out[2] = vel
+		next(out)
+	}
+}
+
+// Gamma returns a velocity curve for VelocityCurve: values below 1 make soft notes louder, values above 1 softer.
+func Gamma(gamma float64) func(velocity uint8) uint8 {
+	return func(velocity uint8) uint8 {
+		return uint8(math.Round(127 * math.Pow(float64(velocity)/127, gamma)))
+	}
+}
+
+// RemapChannels moves the channel messages of the channels in the mapping (1-16) to the mapped channels.
+// The messages of other channels pass unchanged.
+func RemapChannels(mapping map[int]int) Handler {
+	var to [16]int
+	for from, ch := range mapping {
+		if from >= 1 && from <= 16 && ch >= 1 && ch <= 16 {
+			to[from-1] = ch
+		}
+	}
+
+	return func(msg []byte, next func([]byte)) {
+		if !isChannelMessage(msg) {
+			next(msg)
+			return
+		}
+
+		ch := to[msg[0]&0x0F]
+		if ch == 0 || ch-1 == int(msg[0]&0x0F) {
+			next(msg)
+			return
+		}
+
+		out := clone(msg)
+		out[0] = out[0]&0xF0 | byte(ch-1)
+		next(out)
+	}
+}
+
+// RemapControllers changes the controller numbers of control change messages according to the mapping.
+// The other controllers pass unchanged.
+func RemapControllers(mapping map[uint8]uint8) Handler {
+	to := map[uint8]uint8{}
+	for from, cc := range mapping {
+		to[from] = cc
+	}
+
+	return func(msg []byte, next func([]byte)) {
+		if len(msg) != 3 || msg[0]&0xF0 != 0xB0 {
+			next(msg)
+			return
+		}
+
+		cc, has := to[msg[1]]
+		if !has || cc > 127 || cc == msg[1] {
+			next(msg)
+			return
+		}
+
+		out := clone(msg)
+		out[1] = cc
+		next(out)
+	}
+}
+
+// Split sends the note on, note off and polyphonic aftertouch messages with keys between min and max (inclusive)
+// to the other pipeline instead of passing them on; they arrive there with a delta of 0.
+// The other messages pass, e.g. for a keyboard split:
+//
+//	bass := pipeline.New(pipeline.RemapChannels(map[int]int{1: 2}))
+//	p := pipeline.New(pipeline.Split(0, 59, bass))
+func Split(min, max uint8, to *Pipeline) Handler {
+	return func(msg []byte, next func([]byte)) {
+		if isNote(msg) && msg[1] >= min && msg[1] <= max {
+			to.Process(msg, 0)
+			return
+		}
+		next(msg)
+	}
+}
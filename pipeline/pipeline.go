@@ -0,0 +1,115 @@
+// Package pipeline chains handlers that filter and transform MIDI messages between a MIDI in port and
+// sinks, e.g. listeners or MIDI out ports.
+//
+// A pipeline that plays the notes of channel 1 one octave higher on channel 2 of a synth:
+//
+//	p := pipeline.New(
+//		pipeline.Channels(1),
+//		pipeline.Transpose(12),
+//		pipeline.RemapChannels(map[int]int{1: 2}),
+//	)
+//	p.SetSinks(pipeline.ToOut(synth, nil))
+//	err := p.Listen(in)
+//
+// The handlers and sinks can be replaced while the in port is listening.
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gomidi/connect"
+)
+
+// Handler is a stage of a pipeline. It calls next for every message it passes on: not at all to drop the message,
+// more than once to split it into several messages. Handlers must not modify msg; they pass on a modified copy instead.
+type Handler func(msg []byte, next func([]byte))
+
+// Sink gets the messages at the end of a pipeline. It has the signature of a listener of connect.In.
+type Sink func(data []byte, deltaMicroseconds int64)
+
+// Pipeline passes the messages through its handlers to its sinks.
+// All methods are safe for concurrent use; a change of the handlers or sinks applies to the next message.
+type Pipeline struct {
+	// stages holds the current *stages. Process only loads it, while mx serializes the changes.
+	stages atomic.Value
+	mx     sync.Mutex
+}
+
+type stages struct {
+	handlers []Handler
+	sinks    []Sink
+}
+
+// New returns a pipeline with the given handlers and without sinks.
+func New(handlers ...Handler) *Pipeline {
+	p := &Pipeline{}
+	p.stages.Store(&stages{handlers: handlers})
+	return p
+}
+
+// SetHandlers replaces the handlers of the pipeline.
+// Notes that are held when the handlers are replaced may not be released at the sinks,
+// e.g. if the transposition has changed in between.
+func (p *Pipeline) SetHandlers(handlers ...Handler) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	old := p.stages.Load().(*stages)
+	p.stages.Store(&stages{handlers: handlers, sinks: old.sinks})
+}
+
+// SetSinks replaces the sinks of the pipeline.
+func (p *Pipeline) SetSinks(sinks ...Sink) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	old := p.stages.Load().(*stages)
+	p.stages.Store(&stages{handlers: old.handlers, sinks: sinks})
+}
+
+// Process passes a message through the pipeline. It can be used as listener of a connect.In
+// and as Sink of another pipeline.
+func (p *Pipeline) Process(data []byte, deltaMicroseconds int64) {
+	if len(data) == 0 {
+		return
+	}
+	p.stages.Load().(*stages).run(0, data, deltaMicroseconds)
+}
+
+// Listen sets the pipeline as the listener of the in port.
+func (p *Pipeline) Listen(in connect.In) error {
+	return in.SetListener(p.Process)
+}
+
+func (s *stages) run(i int, msg []byte, deltaMicroseconds int64) {
+	if i == len(s.handlers) {
+		for _, sink := range s.sinks {
+			sink(msg, deltaMicroseconds)
+		}
+		return
+	}
+
+	s.handlers[i](msg, func(out []byte) {
+		if len(out) > 0 {
+			s.run(i+1, out, deltaMicroseconds)
+		}
+	})
+}
+
+// ToOut returns a sink that sends the messages to the out port. Errors are passed to onError, if it is not nil.
+func ToOut(out connect.Out, onError func(error)) Sink {
+	return func(data []byte, _ int64) {
+		err := out.Send(data)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Apply returns the messages that the handler passes on for msg. It is meant for testing handlers.
+func Apply(h Handler, msg []byte) [][]byte {
+	var out [][]byte
+	h(msg, func(m []byte) {
+		out = append(out, m)
+	})
+	return out
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/minikomi/rtmididrv"
+)
+
+func format(msgs [][]byte) string {
+	s := make([]string, len(msgs))
+	for i, m := range msgs {
+		s[i] = fmt.Sprintf("% X", m)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestHandlers(t *testing.T) {
+	tests := []struct {
+		handler Handler
+		msg     []byte
+		want    string
+	}{
+		{Channels(1, 3), []byte{0x90, 60, 100}, "90 3C 64"},
+		{Channels(1, 3), []byte{0x92, 60, 100}, "92 3C 64"},
+		{Channels(1, 3), []byte{0x91, 60, 100}, ""},
+		{Channels(1, 3), []byte{0xF8}, "F8"},
+		{Types(rtmididrv.StatusNoteOn), []byte{0x90, 60, 100}, "90 3C 64"},
+		{Types(rtmididrv.StatusNoteOn), []byte{0xB0, 7, 100}, ""},
+		{Transpose(12), []byte{0x80, 60, 0}, "80 48 00"},
+		{Transpose(12), []byte{0xA0, 60, 10}, "A0 48 0A"},
+		{Transpose(12), []byte{0x90, 120, 100}, ""},
+		{Transpose(-12), []byte{0x90, 5, 100}, ""},
+		{Transpose(12), []byte{0xB0, 60, 100}, "B0 3C 64"},
+		{VelocityCurve(func(v uint8) uint8 { return v / 2 }), []byte{0x90, 60, 100}, "90 3C 32"},
+		{VelocityCurve(func(v uint8) uint8 { return 0 }), []byte{0x90, 60, 100}, "90 3C 01"},
+		{VelocityCurve(func(v uint8) uint8 { return 200 }), []byte{0x90, 60, 100}, "90 3C 7F"},
+		{VelocityCurve(func(v uint8) uint8 { return 1 }), []byte{0x90, 60, 0}, "90 3C 00"},
+		{VelocityCurve(Gamma(1)), []byte{0x90, 60, 100}, "90 3C 64"},
+		{VelocityCurve(Gamma(2)), []byte{0x90, 60, 127}, "90 3C 7F"},
+		{VelocityCurve(Gamma(2)), []byte{0x90, 60, 64}, "90 3C 20"},
+		{RemapChannels(map[int]int{1: 10}), []byte{0x90, 60, 100}, "99 3C 64"},
+		{RemapChannels(map[int]int{1: 10}), []byte{0xC1, 5}, "C1 05"},
+		{RemapChannels(map[int]int{1: 10}), []byte{0xF0, 1, 0xF7}, "F0 01 F7"},
+		{RemapControllers(map[uint8]uint8{1: 74}), []byte{0xB2, 1, 64}, "B2 4A 40"},
+		{RemapControllers(map[uint8]uint8{1: 74}), []byte{0xB2, 7, 64}, "B2 07 40"},
+	}
+
+	for i, test := range tests {
+		msg := append([]byte(nil), test.msg...)
+		got := format(Apply(test.handler, msg))
+		if got != test.want {
+			t.Errorf("[%v] % X: got %q, want %q", i, test.msg, got, test.want)
+		}
+		if fmt.Sprint(msg) != fmt.Sprint(test.msg) {
+			t.Errorf("[%v] the handler modified the message", i)
+		}
+	}
+}
+
+type testSink struct {
+	sync.Mutex
+	msgs [][]byte
+}
+
+func (s *testSink) sink(data []byte, _ int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.msgs = append(s.msgs, data)
+}
+
+func (s *testSink) String() string {
+	s.Lock()
+	defer s.Unlock()
+	return format(s.msgs)
+}
+
+func TestPipeline(t *testing.T) {
+	var upper, lower testSink
+
+	bass := New(RemapChannels(map[int]int{1: 2}))
+	bass.SetSinks(lower.sink)
+
+	p := New(Channels(1), Split(0, 59, bass), Transpose(12))
+	p.SetSinks(upper.sink)
+
+	p.Process([]byte{0x90, 60, 100}, 0)
+	p.Process([]byte{0x90, 40, 100}, 0)
+	p.Process([]byte{0x91, 61, 100}, 0)
+	p.Process([]byte{0xB0, 64, 127}, 0)
+
+	if got, want := upper.String(), "90 48 64,B0 40 7F"; got != want {
+		t.Errorf("upper: got %s, want %s", got, want)
+	}
+	if got, want := lower.String(), "91 28 64"; got != want {
+		t.Errorf("lower: got %s, want %s", got, want)
+	}
+
+	// hot swap
+	p.SetHandlers(Transpose(-12))
+	p.Process([]byte{0x91, 61, 100}, 0)
+
+	if got, want := upper.String(), "90 48 64,B0 40 7F,91 31 64"; got != want {
+		t.Errorf("after swap: got %s, want %s", got, want)
+	}
+
+	p.SetSinks()
+	p.Process([]byte{0x91, 61, 100}, 0)
+	if got, want := upper.String(), "90 48 64,B0 40 7F,91 31 64"; got != want {
+		t.Errorf("without sinks: got %s, want %s", got, want)
+	}
+}
+
+func TestConcurrentSwap(t *testing.T) {
+	var s testSink
+	p := New()
+	p.SetSinks(s.sink)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p.SetHandlers(Transpose(i % 12))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		p.Process([]byte{0x90, 60, 100}, 0)
+	}
+	wg.Wait()
+
+	s.Lock()
+	defer s.Unlock()
+	if len(s.msgs) != 100 {
+		t.Errorf("expected 100 messages, got %v", len(s.msgs))
+	}
+}
+
+func TestConcurrentSet(t *testing.T) {
+	for n := 0; n < 20; n++ {
+		var s testSink
+		p := New()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				p.SetHandlers(Transpose(i))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				p.SetSinks(s.sink)
+			}
+		}()
+		wg.Wait()
+
+		// neither change may get lost
+		p.Process([]byte{0x90, 60, 100}, 0)
+		if got, want := s.String(), "90 45 64"; got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,644 @@
+// Package player plays Standard MIDI Files to the MIDI out ports of a rtmididrv.Driver.
+package player
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minikomi/rtmididrv/smf"
+)
+
+// Out is the part of an out port that the player uses. It is implemented by rtmididrv.Out.
+type Out interface {
+	Send([]byte) error
+}
+
+// Player plays a Standard MIDI File of format 0 or 1. The tracks of the file are merged and played
+// with the tempo map of the file, scaled by the tempo scale of the player.
+//
+// Every message is scheduled at its absolute time since the start of the playback, so that
+// delays of single messages do not add up. The tracks of multi-port files are sent to the out
+// of their port (see the MIDI port meta event); tracks without port meta event use port 0.
+//
+// When the playback stops before the end of the file, note offs are sent for the notes that are held,
+// followed by all notes off and sustain off on every channel that has been used.
+type Player struct {
+	// ctl serializes the methods that start and stop the playback
+	ctl sync.Mutex
+
+	mx           sync.Mutex
+	ppq          int64
+	events       []event
+	tempos       []tempoChange
+	signatures   []signature
+	length       int64 // in ticks
+	lengthUs     int64
+	outs         map[int]Out
+	muted        map[int]bool
+	held         map[heldNote]bool
+	used         map[portChannel]bool
+	scale        float64
+	loop         bool
+	errorHandler func(error)
+
+	// errs are the errors of sending that are waiting for the error handler; reporting is set
+	// while a goroutine passes them to it (see report)
+	errs      []error
+	reporting bool
+
+	// the playback position: the next event and the time in the file, in microseconds at a tempo scale of 1
+	next  int
+	posUs int64
+
+	// the running playback: it has played the time fromUs at wall time
+	running bool
+	wall    time.Time
+	fromUs  int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type event struct {
+	tick  int64
+	us    int64
+	track int
+	port  int
+	data  []byte
+}
+
+type tempoChange struct {
+	tick  int64
+	us    int64
+	tempo int64 // microseconds per quarter note
+}
+
+type signature struct {
+	tick         int64
+	bar          int // the number of bars before tick, counted from 0
+	beats        int
+	ticksPerBeat int64
+}
+
+type heldNote struct {
+	track   int
+	port    int
+	channel byte
+	key     byte
+}
+
+type portChannel struct {
+	port    int
+	channel byte
+}
+
+// New returns a player for the given file. outs[i] is the out for the tracks of port i.
+// Messages for ports without out are not sent.
+func New(f *smf.File, outs ...Out) (*Player, error) {
+	if f.Format != 0 && f.Format != 1 {
+		return nil, fmt.Errorf("can't play SMF format %v", f.Format)
+	}
+	if f.PPQ <= 0 {
+		return nil, fmt.Errorf("can't play SMF: invalid PPQ %v", f.PPQ)
+	}
+
+	p := &Player{
+		ppq:   int64(f.PPQ),
+		outs:  map[int]Out{},
+		muted: map[int]bool{},
+		held:  map[heldNote]bool{},
+		used:  map[portChannel]bool{},
+		scale: 1,
+	}
+
+	for i, out := range outs {
+		if out != nil {
+			p.outs[i] = out
+		}
+	}
+
+	p.load(f)
+	return p, nil
+}
+
+// load merges the tracks and builds the tempo map and the time signatures.
+func (p *Player) load(f *smf.File) {
+	var metas []event
+
+	for i, t := range f.Tracks {
+		var tick int64
+		port := 0
+		for _, ev := range t {
+			tick += int64(ev.Delta)
+			if len(ev.Data) == 0 {
+				continue
+			}
+			if typ, payload, ok := smf.IsMeta(ev.Data); ok {
+				switch typ {
+				case smf.MetaPort:
+					if len(payload) == 1 {
+						port = int(payload[0])
+					}
+				case smf.MetaTempo, smf.MetaTimeSignature:
+					metas = append(metas, event{tick: tick, track: i, data: ev.Data})
+				}
+				continue
+			}
+			p.events = append(p.events, event{tick: tick, track: i, port: port, data: ev.Data})
+		}
+		if tick > p.length {
+			p.length = tick
+		}
+	}
+
+	// the stable sort keeps the order within a track and plays simultaneous events in the order of the tracks
+	sort.SliceStable(p.events, func(a, b int) bool {
+		if p.events[a].tick != p.events[b].tick {
+			return p.events[a].tick < p.events[b].tick
+		}
+		return p.events[a].track < p.events[b].track
+	})
+	sort.SliceStable(metas, func(a, b int) bool {
+		return metas[a].tick < metas[b].tick
+	})
+
+	p.tempos = []tempoChange{{tempo: smf.DefaultTempo}}
+	p.signatures = []signature{{beats: 4, ticksPerBeat: p.ppq}}
+
+	for _, m := range metas {
+		typ, payload, _ := smf.IsMeta(m.data)
+		switch {
+		case typ == smf.MetaTempo && len(payload) == 3:
+			tempo := int64(payload[0])<<16 | int64(payload[1])<<8 | int64(payload[2])
+			if tempo == 0 {
+				continue
+			}
+			last := p.tempos[len(p.tempos)-1]
+			tc := tempoChange{tick: m.tick, us: p.usAt(m.tick), tempo: tempo}
+			if last.tick == m.tick {
+				p.tempos[len(p.tempos)-1] = tc
+			} else {
+				p.tempos = append(p.tempos, tc)
+			}
+		case typ == smf.MetaTimeSignature && len(payload) >= 2 && payload[0] > 0 && payload[1] <= 6:
+			last := p.signatures[len(p.signatures)-1]
+			barTicks := int64(last.beats) * last.ticksPerBeat
+			// a new time signature starts a new bar
+			bars := (m.tick - last.tick + barTicks - 1) / barTicks
+			s := signature{
+				tick:         m.tick,
+				bar:          last.bar + int(bars),
+				beats:        int(payload[0]),
+				ticksPerBeat: p.ppq * 4 >> payload[1],
+			}
+			if s.ticksPerBeat == 0 {
+				continue
+			}
+			if last.tick == m.tick {
+				p.signatures[len(p.signatures)-1] = s
+			} else {
+				p.signatures = append(p.signatures, s)
+			}
+		}
+	}
+
+	for i := range p.events {
+		p.events[i].us = p.usAt(p.events[i].tick)
+	}
+	p.lengthUs = p.usAt(p.length)
+}
+
+// usAt returns the time of the given tick in microseconds at a tempo scale of 1.
+func (p *Player) usAt(tick int64) int64 {
+	i := sort.Search(len(p.tempos), func(i int) bool { return p.tempos[i].tick > tick }) - 1
+	tc := p.tempos[i]
+	return tc.us + (tick-tc.tick)*tc.tempo/p.ppq
+}
+
+// tickAt returns the tick at the given time in microseconds at a tempo scale of 1.
+func (p *Player) tickAt(us int64) int64 {
+	i := sort.Search(len(p.tempos), func(i int) bool { return p.tempos[i].us > us }) - 1
+	if i < 0 {
+		i = 0
+	}
+	tc := p.tempos[i]
+	return tc.tick + (us-tc.us)*p.ppq/tc.tempo
+}
+
+// Length returns the length of the file in ticks.
+func (p *Player) Length() int64 {
+	return p.length
+}
+
+// Duration returns the duration of the file at a tempo scale of 1.
+func (p *Player) Duration() time.Duration {
+	return time.Duration(p.lengthUs) * time.Microsecond
+}
+
+// BarBeat returns the bar and beat of the given tick according to the time signatures of the file,
+// both counted from 1.
+func (p *Player) BarBeat(tick int64) (bar, beat int) {
+	i := sort.Search(len(p.signatures), func(i int) bool { return p.signatures[i].tick > tick }) - 1
+	s := p.signatures[i]
+	beats := (tick - s.tick) / s.ticksPerBeat
+	return s.bar + int(beats)/s.beats + 1, int(beats)%s.beats + 1
+}
+
+// barTick returns the tick of the given bar, counted from 1.
+func (p *Player) barTick(bar int) int64 {
+	i := sort.Search(len(p.signatures), func(i int) bool { return p.signatures[i].bar > bar-1 }) - 1
+	s := p.signatures[i]
+	return s.tick + int64(bar-1-s.bar)*int64(s.beats)*s.ticksPerBeat
+}
+
+// SetOut sets the out for the tracks of the given port. A nil out mutes the port.
+func (p *Player) SetOut(port int, out Out) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.releasePort(port)
+	if out == nil {
+		delete(p.outs, port)
+		return
+	}
+	p.outs[port] = out
+}
+
+// SetErrorHandler sets a function that is called with the errors of sending messages.
+// The playback continues after errors. The handler is called in the order of the errors,
+// but on a goroutine of its own and without holding a lock, so that it may call the methods of the player, e.g. Stop.
+func (p *Player) SetErrorHandler(handler func(error)) {
+	p.mx.Lock()
+	p.errorHandler = handler
+	p.mx.Unlock()
+}
+
+// SetLoop sets whether the playback starts again at the beginning when it reaches the end of the file.
+func (p *Player) SetLoop(loop bool) {
+	p.mx.Lock()
+	p.loop = loop
+	p.mx.Unlock()
+}
+
+// Mute mutes or unmutes the track with the given index. The held notes of a muted track are released.
+func (p *Player) Mute(track int, mute bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.muted[track] = mute
+	if !mute {
+		return
+	}
+	for n := range p.held {
+		if n.track == track {
+			p.noteOff(n)
+		}
+	}
+}
+
+// SetTempoScale scales the tempo of the file, e.g. 2 plays twice as fast. It can be changed while playing.
+func (p *Player) SetTempoScale(scale float64) error {
+	if scale <= 0 {
+		return fmt.Errorf("invalid tempo scale %v", scale)
+	}
+
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+
+	running := p.halt()
+	p.mx.Lock()
+	p.scale = scale
+	p.mx.Unlock()
+	if running {
+		p.start()
+	}
+	return nil
+}
+
+// Position returns the current position of the playback in ticks.
+func (p *Player) Position() int64 {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.tickAt(p.currentUs(time.Now()))
+}
+
+// Playing reports whether the player is playing.
+func (p *Player) Playing() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.running
+}
+
+// Start starts the playback at the current position. It does nothing, if the player is already playing.
+func (p *Player) Start() {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+
+	p.mx.Lock()
+	running := p.running
+	p.mx.Unlock()
+
+	if !running {
+		p.start()
+	}
+}
+
+// Stop stops the playback and releases the held notes. The position is kept, so that Start continues there.
+func (p *Player) Stop() {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+
+	p.halt()
+	p.mx.Lock()
+	p.allNotesOff()
+	p.mx.Unlock()
+}
+
+// Wait waits until the playback has stopped, either by Stop or at the end of the file.
+func (p *Player) Wait() {
+	p.mx.Lock()
+	done := p.done
+	p.mx.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// SeekTick sets the position to the given tick. When playing, the held notes are released and the playback
+// continues at the new position.
+func (p *Player) SeekTick(tick int64) error {
+	if tick < 0 || tick > p.length {
+		return fmt.Errorf("can't seek to tick %v: out of range 0-%v", tick, p.length)
+	}
+
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+
+	running := p.halt()
+
+	p.mx.Lock()
+	p.allNotesOff()
+	p.next = sort.Search(len(p.events), func(i int) bool { return p.events[i].tick >= tick })
+	p.posUs = p.usAt(tick)
+	p.mx.Unlock()
+
+	if running {
+		p.start()
+	}
+	return nil
+}
+
+// SeekBar sets the position to the start of the given bar, counted from 1 (see SeekTick).
+func (p *Player) SeekBar(bar int) error {
+	if bar < 1 {
+		return fmt.Errorf("can't seek to bar %v", bar)
+	}
+	return p.SeekTick(p.barTick(bar))
+}
+
+// SeekTime sets the position to the given time at a tempo scale of 1 (see SeekTick).
+func (p *Player) SeekTime(d time.Duration) error {
+	if d < 0 || d > p.Duration() {
+		return fmt.Errorf("can't seek to %v: out of range 0-%v", d, p.Duration())
+	}
+	return p.SeekTick(p.tickAt(d.Microseconds()))
+}
+
+// currentUs returns the position in the file at the given wall time. It must be called with the lock held.
+func (p *Player) currentUs(now time.Time) int64 {
+	if !p.running {
+		return p.posUs
+	}
+	us := p.fromUs + int64(float64(now.Sub(p.wall).Microseconds())*p.scale)
+	if p.next < len(p.events) && us > p.events[p.next].us {
+		// the next event is late: the playback has not passed it yet
+		us = p.events[p.next].us
+	}
+	if us > p.lengthUs {
+		us = p.lengthUs
+	}
+	return us
+}
+
+// start starts the playback goroutine. It must be called with ctl held and without playback running.
+func (p *Player) start() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.next >= len(p.events) && p.posUs >= p.lengthUs {
+		// at the end: start again
+		p.next = 0
+		p.posUs = 0
+	}
+
+	p.running = true
+	p.wall = time.Now()
+	p.fromUs = p.posUs
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run(p.stop, p.done)
+}
+
+// halt stops the playback goroutine and keeps the position. It must be called with ctl held.
+// It reports whether the player was playing.
+func (p *Player) halt() bool {
+	p.mx.Lock()
+	if !p.running {
+		p.mx.Unlock()
+		return false
+	}
+	close(p.stop)
+	done := p.done
+	p.mx.Unlock()
+
+	<-done
+	return true
+}
+
+func (p *Player) run(stop, done chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	defer close(done)
+
+	for {
+		p.mx.Lock()
+
+		if p.next >= len(p.events) {
+			if !p.loop || len(p.events) == 0 {
+				// wait for the end of the last track
+				target := p.wall.Add(p.scaled(p.lengthUs - p.fromUs))
+				p.mx.Unlock()
+				if !wait(timer, target, stop) {
+					p.finish()
+					return
+				}
+				p.mx.Lock()
+				p.running = false
+				p.posUs = p.lengthUs
+				p.mx.Unlock()
+				return
+			}
+
+			// loop: the start of the file follows the end of the file
+			p.allNotesOff()
+			p.wall = p.wall.Add(p.scaled(p.lengthUs - p.fromUs))
+			p.fromUs = 0
+			p.next = 0
+		}
+
+		target := p.wall.Add(p.scaled(p.events[p.next].us - p.fromUs))
+		p.mx.Unlock()
+
+		if !wait(timer, target, stop) {
+			p.finish()
+			return
+		}
+
+		p.mx.Lock()
+		select {
+		case <-stop:
+			p.mx.Unlock()
+			p.finish()
+			return
+		default:
+		}
+		p.play(p.events[p.next])
+		p.next++
+		p.mx.Unlock()
+	}
+}
+
+// finish keeps the position, when the playback is stopped.
+func (p *Player) finish() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.posUs = p.currentUs(time.Now())
+	p.running = false
+}
+
+// scaled returns the wall time of the given duration in the file. It must be called with the lock held.
+func (p *Player) scaled(us int64) time.Duration {
+	return time.Duration(float64(us)/p.scale) * time.Microsecond
+}
+
+// wait waits until the target time. It returns false, if the playback has been stopped.
+func wait(timer *time.Timer, target time.Time, stop chan struct{}) bool {
+	d := time.Until(target)
+	if d <= 0 {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer.Reset(d)
+	select {
+	case <-stop:
+		if !timer.Stop() {
+			<-timer.C
+		}
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// play sends the event and keeps track of the held notes. It must be called with the lock held.
+func (p *Player) play(ev event) {
+	if p.muted[ev.track] {
+		return
+	}
+
+	out := p.outs[ev.port]
+	if out == nil {
+		return
+	}
+
+	d := ev.data
+	if d[0] >= 0x80 && d[0] < 0xF0 {
+		p.used[portChannel{port: ev.port, channel: d[0] & 0x0F}] = true
+
+		if len(d) == 3 && (d[0]&0xF0 == 0x90 || d[0]&0xF0 == 0x80) {
+			n := heldNote{track: ev.track, port: ev.port, channel: d[0] & 0x0F, key: d[1]}
+			if d[0]&0xF0 == 0x90 && d[2] > 0 {
+				p.held[n] = true
+			} else {
+				delete(p.held, n)
+			}
+		}
+	}
+
+	p.send(out, d)
+}
+
+// send sends the data. It must be called with the lock held.
+func (p *Player) send(out Out, data []byte) {
+	err := out.Send(data)
+	if err == nil || p.errorHandler == nil {
+		return
+	}
+	p.errs = append(p.errs, fmt.Errorf("can't send % X: %v", data, err))
+	if !p.reporting {
+		p.reporting = true
+		go p.report()
+	}
+}
+
+// report passes the errors of sending to the error handler, until there are no more.
+func (p *Player) report() {
+	for {
+		p.mx.Lock()
+		errs, handler := p.errs, p.errorHandler
+		p.errs = nil
+		if len(errs) == 0 {
+			p.reporting = false
+			p.mx.Unlock()
+			return
+		}
+		p.mx.Unlock()
+
+		if handler != nil {
+			for _, err := range errs {
+				handler(err)
+			}
+		}
+	}
+}
+
+// noteOff releases a held note. It must be called with the lock held.
+func (p *Player) noteOff(n heldNote) {
+	delete(p.held, n)
+	if out := p.outs[n.port]; out != nil {
+		p.send(out, []byte{0x80 | n.channel, n.key, 0})
+	}
+}
+
+// releasePort releases the held notes of the port. It must be called with the lock held.
+func (p *Player) releasePort(port int) {
+	for n := range p.held {
+		if n.port == port {
+			p.noteOff(n)
+		}
+	}
+}
+
+// allNotesOff releases the held notes and sends all notes off and sustain off on the used channels.
+// It must be called with the lock held.
+func (p *Player) allNotesOff() {
+	for n := range p.held {
+		p.noteOff(n)
+	}
+
+	for pc := range p.used {
+		if out := p.outs[pc.port]; out != nil {
+			p.send(out, []byte{0xB0 | pc.channel, 123, 0})
+			p.send(out, []byte{0xB0 | pc.channel, 64, 0})
+		}
+		delete(p.used, pc)
+	}
+}
@@ -0,0 +1,277 @@
+package player
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minikomi/rtmididrv/smf"
+)
+
+type testOut struct {
+	sync.Mutex
+	msgs  [][]byte
+	times []time.Time
+}
+
+func (o *testOut) Send(data []byte) error {
+	o.Lock()
+	defer o.Unlock()
+	o.msgs = append(o.msgs, append([]byte(nil), data...))
+	o.times = append(o.times, time.Now())
+	return nil
+}
+
+func (o *testOut) String() string {
+	o.Lock()
+	defer o.Unlock()
+	var bf bytes.Buffer
+	for _, m := range o.msgs {
+		fmt.Fprintf(&bf, "[% X]", m)
+	}
+	return bf.String()
+}
+
+func TestTempoMap(t *testing.T) {
+	f := &smf.File{PPQ: 100, Tracks: []smf.Track{{
+		{Data: smf.TimeSignature(3, 4)},
+		// a quarter note takes 500ms until tick 200, then 250ms
+		{Delta: 200, Data: smf.Tempo(250000)},
+		// 3/4 for two bars, then 6/8
+		{Delta: 400, Data: smf.TimeSignature(6, 8)},
+		{Delta: 600, Data: []byte{0x90, 60, 100}},
+	}}}
+
+	p, err := New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		tick int64
+		us   int64
+	}{
+		{0, 0},
+		{100, 500000},
+		{200, 1000000},
+		{300, 1250000},
+		{1200, 3500000},
+	} {
+		if got := p.usAt(test.tick); got != test.us {
+			t.Errorf("usAt(%v) = %v, want %v", test.tick, got, test.us)
+		}
+		if got := p.tickAt(test.us); got != test.tick {
+			t.Errorf("tickAt(%v) = %v, want %v", test.us, got, test.tick)
+		}
+	}
+
+	if p.Duration() != 3500*time.Millisecond {
+		t.Errorf("duration %v", p.Duration())
+	}
+
+	for _, test := range []struct {
+		tick      int64
+		bar, beat int
+	}{
+		{0, 1, 1},
+		{299, 1, 3},
+		{300, 2, 1},
+		{600, 3, 1},
+		{650, 3, 2},
+		{900, 4, 1},
+	} {
+		bar, beat := p.BarBeat(test.tick)
+		if bar != test.bar || beat != test.beat {
+			t.Errorf("BarBeat(%v) = %v.%v, want %v.%v", test.tick, bar, beat, test.bar, test.beat)
+		}
+		if test.beat == 1 && p.barTick(test.bar) != test.tick {
+			t.Errorf("barTick(%v) = %v, want %v", test.bar, p.barTick(test.bar), test.tick)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	// at 600 BPM with 10 ticks per quarter note, a tick takes 10ms
+	f := &smf.File{Format: 1, PPQ: 10, Tracks: []smf.Track{
+		{
+			{Data: smf.Tempo(100000)},
+		},
+		{
+			{Data: []byte{0x90, 60, 100}},
+			{Delta: 5, Data: []byte{0x80, 60, 0}},
+		},
+		{
+			{Data: smf.Port(1)},
+			{Delta: 2, Data: []byte{0x91, 64, 100}},
+			{Delta: 2, Data: []byte{0x81, 64, 0}},
+			{Delta: 2, Data: []byte{0xF0, 0x43, 0xF7}},
+		},
+		{
+			{Data: []byte{0x92, 67, 100}},
+		},
+	}}
+
+	var out0, out1 testOut
+	p, err := New(f, &out0, &out1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Mute(3, true)
+
+	start := time.Now()
+	p.Start()
+	p.Wait()
+
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("played too fast: %v", d)
+	}
+
+	if got, want := out0.String(), "[90 3C 64][80 3C 00]"; got != want {
+		t.Errorf("port 0: got %s, want %s", got, want)
+	}
+	if got, want := out1.String(), "[91 40 64][81 40 00][F0 43 F7]"; got != want {
+		t.Errorf("port 1: got %s, want %s", got, want)
+	}
+
+	if d := out0.times[1].Sub(out0.times[0]); d < 50*time.Millisecond {
+		t.Errorf("note off after %v, want 50ms", d)
+	}
+
+	if p.Playing() || p.Position() != p.Length() {
+		t.Errorf("not at the end: playing %v, position %v", p.Playing(), p.Position())
+	}
+}
+
+func TestStop(t *testing.T) {
+	f := &smf.File{PPQ: 10, Tracks: []smf.Track{{
+		{Data: smf.Tempo(100000)},
+		{Data: []byte{0x90, 60, 100}},
+		{Delta: 1000, Data: []byte{0x80, 60, 0}},
+	}}}
+
+	var out testOut
+	p, err := New(f, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Start()
+	time.Sleep(50 * time.Millisecond)
+	p.Stop()
+
+	if got, want := out.String(), "[90 3C 64][80 3C 00][B0 7B 00][B0 40 00]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	pos := p.Position()
+	if pos < 4 || pos >= 1000 {
+		t.Errorf("position %v after stop", pos)
+	}
+
+	// the note on is not played again and the note off comes at its time
+	err = p.SeekTick(990)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	p.Wait()
+
+	if got, want := out.String(), "[90 3C 64][80 3C 00][B0 7B 00][B0 40 00][80 3C 00]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+type failingOut struct{}
+
+func (failingOut) Send([]byte) error { return fmt.Errorf("unplugged") }
+
+func TestErrorHandlerStops(t *testing.T) {
+	f := &smf.File{PPQ: 10, Tracks: []smf.Track{{
+		{Data: smf.Tempo(100000)},
+		{Data: []byte{0x90, 60, 100}},
+		{Delta: 1000, Data: []byte{0x80, 60, 0}},
+	}}}
+
+	p, err := New(f, failingOut{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 10)
+	p.SetErrorHandler(func(err error) {
+		// the handler may control the player
+		p.Stop()
+		errs <- err
+	})
+
+	p.Start()
+
+	select {
+	case err := <-errs:
+		if err.Error() != "can't send 90 3C 64: unplugged" {
+			t.Errorf("got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("error handler not called or deadlocked")
+	}
+
+	p.Wait()
+	if p.Playing() {
+		t.Errorf("still playing")
+	}
+}
+
+func TestLoop(t *testing.T) {
+	f := &smf.File{PPQ: 10, Tracks: []smf.Track{{
+		{Data: smf.Tempo(100000)},
+		{Data: []byte{0x90, 60, 100}},
+		{Delta: 2, Data: []byte{0x80, 60, 0}},
+		{Delta: 1, Data: smf.EndOfTrack()},
+	}}}
+
+	var out testOut
+	p, err := New(f, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.SetLoop(true)
+	err = p.SetTempoScale(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		out.Lock()
+		n := len(out.msgs)
+		out.Unlock()
+		if n >= 6 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Stop()
+
+	out.Lock()
+	defer out.Unlock()
+
+	if len(out.msgs) < 6 {
+		t.Fatalf("expected the file to be played more than once, got %v", out.msgs)
+	}
+
+	// every loop releases the used channels
+	for i, want := range []string{"90 3C 64", "80 3C 00", "B0 7B 00", "B0 40 00", "90 3C 64", "80 3C 00"} {
+		if got := fmt.Sprintf("% X", out.msgs[i]); got != want {
+			t.Errorf("message %v: got %s, want %s", i, got, want)
+		}
+	}
+
+	// at a tempo scale of 2 the file takes 15ms
+	if d := out.times[4].Sub(out.times[0]); d < 15*time.Millisecond {
+		t.Errorf("looped after %v, want 15ms", d)
+	}
+}
@@ -0,0 +1,108 @@
+package rtmididrv
+
+import (
+	"sync/atomic"
+)
+
+// DefaultQueueCapacity is the number of incoming messages that a MIDI in port buffers by default
+// between the native input thread and the listeners.
+const DefaultQueueCapacity = 1024
+
+// queue is a lock-free single-producer single-consumer ring buffer for incoming messages.
+// The producer is the native input thread, the consumer is the dispatch goroutine of the port.
+// The buffers of the slots are reused, so that no memory is allocated per message once they are big enough.
+type queue struct {
+	slots []queueSlot
+	mask  uint64
+
+	// head is the next slot to read, only written by the consumer.
+	head uint64
+	// tail is the next slot to write, only written by the producer.
+	tail uint64
+
+	// sleeping is 1 while the consumer waits for wake.
+	sleeping int32
+	wake     chan struct{}
+
+	// stats counts the messages that did not fit and the high water mark.
+	stats *portStats
+}
+
+type queueSlot struct {
+	data                  []byte
+	timestampMicroseconds int64
+	deltaMicroseconds     int64
+}
+
+// newQueue returns a queue that holds at least capacity messages.
+// Dropped messages and the high water mark are recorded in stats.
+func newQueue(capacity int, stats *portStats) *queue {
+	n := 1
+	for n < capacity {
+		n <<= 1
+	}
+	return &queue{
+		slots: make([]queueSlot, n),
+		mask:  uint64(n - 1),
+		wake:  make(chan struct{}, 1),
+		stats: stats,
+	}
+}
+
+// push adds a copy of data to the queue. If the queue is full, the message is dropped and false is returned.
+// push never blocks and must only be called by the producer.
+func (q *queue) push(data []byte, timestampMicroseconds, deltaMicroseconds int64) bool {
+	t := q.tail
+	n := t - atomic.LoadUint64(&q.head)
+	if n > q.mask {
+		atomic.AddUint64(&q.stats.dropped, 1)
+		return false
+	}
+	q.stats.queued(n + 1)
+
+	s := &q.slots[t&q.mask]
+	s.data = append(s.data[:0], data...)
+	s.timestampMicroseconds = timestampMicroseconds
+	s.deltaMicroseconds = deltaMicroseconds
+	atomic.StoreUint64(&q.tail, t+1)
+
+	if atomic.CompareAndSwapInt32(&q.sleeping, 1, 0) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// consume passes the messages to fn until stop is closed. data is only valid until fn returns.
+// consume must only be called by the consumer.
+func (q *queue) consume(stop <-chan struct{}, fn func(data []byte, timestampMicroseconds, deltaMicroseconds int64)) {
+	for {
+		h := q.head
+		if h == atomic.LoadUint64(&q.tail) {
+			atomic.StoreInt32(&q.sleeping, 1)
+			// the producer might have pushed before it could see that we sleep
+			if h != atomic.LoadUint64(&q.tail) {
+				atomic.StoreInt32(&q.sleeping, 0)
+				continue
+			}
+			select {
+			case <-q.wake:
+			case <-stop:
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		s := &q.slots[h&q.mask]
+		fn(s.data, s.timestampMicroseconds, s.deltaMicroseconds)
+		atomic.StoreUint64(&q.head, h+1)
+	}
+}
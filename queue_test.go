@@ -0,0 +1,74 @@
+package rtmididrv
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestQueueOrder(t *testing.T) {
+	var stats portStats
+	q := newQueue(8, &stats)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	const n = 10000
+	var got int64
+
+	go func() {
+		q.consume(stop, func(data []byte, timestampMicroseconds, deltaMicroseconds int64) {
+			if int64(data[0]) != deltaMicroseconds%256 {
+				t.Errorf("data %v does not match delta %v", data[0], deltaMicroseconds)
+			}
+			if deltaMicroseconds < got {
+				t.Errorf("got %v after %v", deltaMicroseconds, got)
+			}
+			got = deltaMicroseconds
+			if deltaMicroseconds == n-1 {
+				close(done)
+			}
+		})
+	}()
+
+	for i := int64(0); i < n; i++ {
+		for !q.push([]byte{byte(i % 256)}, i, i) {
+			runtime.Gosched()
+		}
+	}
+
+	<-done
+	close(stop)
+}
+
+func TestQueueDrop(t *testing.T) {
+	var stats portStats
+	q := newQueue(3, &stats)
+
+	for i := 0; i < 6; i++ {
+		q.push([]byte{0xF8}, 0, 0)
+	}
+
+	if len(q.slots) != 4 {
+		t.Errorf("expected capacity of 4, got %v", len(q.slots))
+	}
+
+	if stats.dropped != 2 {
+		t.Errorf("expected 2 dropped messages, got %v", stats.dropped)
+	}
+
+	if stats.queueHighWater != 4 {
+		t.Errorf("expected high water mark of 4, got %v", stats.queueHighWater)
+	}
+
+	var consumed int
+	stop := make(chan struct{})
+	q.consume(stop, func(data []byte, timestampMicroseconds, deltaMicroseconds int64) {
+		consumed++
+		if consumed == 4 {
+			close(stop)
+		}
+	})
+
+	if consumed != 4 {
+		t.Errorf("expected 4 consumed messages, got %v", consumed)
+	}
+}
@@ -0,0 +1,249 @@
+// Package recorder records the messages of MIDI in ports of a rtmididrv.Driver into Standard MIDI Files.
+package recorder
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/smf"
+)
+
+// Recorder records the messages of one or more MIDI in ports. Every port gets its own track:
+// the file has format 0 for a single port and format 1 for more ports.
+//
+// The timestamps of the messages are converted to ticks with the resolution and tempo of the recorder,
+// counted from the time the recorder has been created. Channel messages and SysEx messages are recorded;
+// the other system messages (e.g. clock and active sensing) are left out.
+// To record SysEx messages, the driver must be created with rtmididrv.IgnoreTypes(false, ...).
+type Recorder struct {
+	drv   *rtmididrv.Driver
+	ppq   int
+	bpm   float64
+	tempo uint32
+	start int64
+
+	mx      sync.Mutex
+	tracks  []*track
+	stopped bool
+}
+
+type track struct {
+	in       rtmididrv.In
+	events   smf.Track
+	lastTick int64
+}
+
+// Option is an option for the recorder.
+type Option func(*Recorder)
+
+// PPQ sets the resolution in ticks per quarter note (1 to 32767). The default is 960.
+func PPQ(ppq int) Option {
+	return func(r *Recorder) {
+		r.ppq = ppq
+	}
+}
+
+// BPM sets the tempo of the file in beats (quarter notes) per minute. Standard MIDI Files allow
+// about 3.6 to 60000000 BPM. The default is 120.
+func BPM(bpm float64) Option {
+	return func(r *Recorder) {
+		r.bpm = bpm
+	}
+}
+
+// New returns a recorder for the ports of the given driver. The recording starts now.
+// An error is returned, if the resolution or the tempo can't be written to a Standard MIDI File.
+func New(drv *rtmididrv.Driver, options ...Option) (*Recorder, error) {
+	r := &Recorder{drv: drv, ppq: 960, bpm: 120}
+	for _, opt := range options {
+		opt(r)
+	}
+
+	if r.ppq < 1 || r.ppq > 0x7FFF {
+		return nil, fmt.Errorf("invalid resolution %v: must be 1..32767 ticks per quarter note", r.ppq)
+	}
+
+	// the tempo is stored in microseconds per quarter note with 24 bits
+	tempo := math.Round(60000000 / r.bpm)
+	if !(r.bpm > 0) || tempo < 1 || tempo > 0xFFFFFF {
+		return nil, fmt.Errorf("invalid tempo %v BPM", r.bpm)
+	}
+	r.tempo = uint32(tempo)
+
+	r.start = drv.Now()
+	return r, nil
+}
+
+// Record records the messages of the given port in a new track. The port is opened, if it is not open.
+func (r *Recorder) Record(in rtmididrv.In) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.stopped {
+		return fmt.Errorf("recorder stopped")
+	}
+
+	if !in.IsOpen() {
+		err := in.Open()
+		if err != nil {
+			return err
+		}
+	}
+
+	t := &track{in: in}
+	err := in.SetTimestampedListener(func(data []byte, timestampMicroseconds, _ int64) {
+		r.add(t, data, timestampMicroseconds)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.tracks = append(r.tracks, t)
+	return nil
+}
+
+// ticks converts a timestamp of the driver to ticks since the start of the recording.
+func (r *Recorder) ticks(timestampMicroseconds int64) int64 {
+	us := timestampMicroseconds - r.start
+	if us < 0 {
+		return 0
+	}
+	tempo := int64(r.tempo)
+	return (us*int64(r.ppq) + tempo/2) / tempo
+}
+
+func (r *Recorder) add(t *track, data []byte, timestampMicroseconds int64) {
+	if len(data) == 0 || data[0] < 0x80 || data[0] > 0xF0 {
+		return
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	tick := r.ticks(timestampMicroseconds)
+	if tick < t.lastTick {
+		tick = t.lastTick
+	}
+
+	t.events = append(t.events, smf.Event{
+		Delta: uint32(tick - t.lastTick),
+		Data:  append([]byte(nil), data...),
+	})
+	t.lastTick = tick
+}
+
+// Stop stops the recording. The ports stop listening, but are not closed.
+// Messages that arrive afterwards are not recorded. It is safe to call Stop more than once.
+func (r *Recorder) Stop() error {
+	r.mx.Lock()
+	if r.stopped {
+		r.mx.Unlock()
+		return nil
+	}
+	r.stopped = true
+	tracks := r.tracks
+	r.mx.Unlock()
+
+	var errs rtmididrv.PortErrors
+	for _, t := range tracks {
+		err := t.in.StopListening()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// File returns the recording so far as Standard MIDI File. Every track starts with the name of its port;
+// the first track also has the tempo.
+func (r *Recorder) File() *smf.File {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	f := &smf.File{PPQ: r.ppq}
+	if len(r.tracks) > 1 {
+		f.Format = 1
+	}
+
+	for i, t := range r.tracks {
+		tr := smf.Track{{Data: smf.TrackName(t.in.String())}}
+		if i == 0 {
+			tr = append(tr, smf.Event{Data: smf.Tempo(r.tempo)})
+		}
+		tr = append(tr, t.events...)
+		f.Tracks = append(f.Tracks, tr)
+	}
+
+	if len(f.Tracks) == 0 {
+		f.Tracks = []smf.Track{{{Data: smf.Tempo(r.tempo)}}}
+	}
+
+	return f
+}
+
+// WriteFile stops the recording and writes it to the given file. The file is written to a temporary file
+// first, that replaces the given one when it is complete, so that it is never left half written,
+// e.g. when the program is ended by a signal while writing.
+// The file is written, even if a port could not stop listening; that error is returned afterwards.
+func (r *Recorder) WriteFile(file string) error {
+	stopErr := r.Stop()
+
+	err := r.writeFile(file)
+	if err != nil {
+		return err
+	}
+	if stopErr != nil {
+		return fmt.Errorf("wrote %s, but can't stop recording: %w", file, stopErr)
+	}
+	return nil
+}
+
+func (r *Recorder) writeFile(file string) error {
+	tmp, err := createTemp(file)
+	if err != nil {
+		return fmt.Errorf("can't write %s: %v", file, err)
+	}
+
+	_, err = r.File().WriteTo(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("can't write %s: %v", file, err)
+	}
+	return nil
+}
+
+// createTemp creates a new temporary file next to the given one. Unlike ioutil.TempFile, it is created
+// with the usual permissions of 0644 (minus the umask), which the file keeps when it is renamed.
+func createTemp(file string) (*os.File, error) {
+	var err error
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("%s.%d.tmp", file, time.Now().UnixNano()+int64(i))
+		var f *os.File
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+	return nil, err
+}
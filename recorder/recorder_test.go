@@ -0,0 +1,154 @@
+package recorder
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/minikomi/rtmididrv"
+	"github.com/minikomi/rtmididrv/smf"
+)
+
+func TestTicks(t *testing.T) {
+	drv, _ := rtmididrv.New()
+	r, err := New(drv, PPQ(480), BPM(120))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &track{}
+
+	// at 120 BPM a quarter note takes 500ms
+	r.add(tr, []byte{0x90, 60, 100}, r.start+500000)
+	r.add(tr, []byte{0xF8}, r.start+600000)
+	r.add(tr, []byte{0x80, 60, 0}, r.start+750000)
+	r.add(tr, []byte{0xF0, 0x43, 0xF7}, r.start+750000)
+
+	if len(tr.events) != 3 {
+		t.Fatalf("expected 3 events, got %v", len(tr.events))
+	}
+
+	for i, want := range []uint32{480, 240, 0} {
+		if got := tr.events[i].Delta; got != want {
+			t.Errorf("event %v: delta %v, want %v", i, got, want)
+		}
+	}
+
+	r.Stop()
+	r.add(tr, []byte{0x90, 60, 100}, r.start+900000)
+	if len(tr.events) != 3 {
+		t.Errorf("recorded after stop")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	drv, _ := rtmididrv.New()
+
+	for _, opts := range [][]Option{
+		{PPQ(0)},
+		{PPQ(-1)},
+		{PPQ(0x8000)},
+		{BPM(0)},
+		{BPM(-120)},
+		{BPM(math.NaN())},
+		{BPM(3)},
+		{BPM(1e9)},
+	} {
+		if r, err := New(drv, opts...); err == nil {
+			t.Errorf("expected error for ppq %v and bpm %v", r.ppq, r.bpm)
+		}
+	}
+
+	r, err := New(drv, PPQ(0x7FFF), BPM(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.tempo != 15000000 {
+		t.Errorf("tempo %v", r.tempo)
+	}
+}
+
+var errStop = errors.New("can't stop")
+
+// failingIn is an in port that can't stop listening.
+type failingIn struct {
+	rtmididrv.In
+}
+
+func (failingIn) String() string       { return "failing" }
+func (failingIn) StopListening() error { return errStop }
+
+func TestWriteFileStopError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	drv, _ := rtmididrv.New()
+	r, err := New(drv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &track{in: failingIn{}}
+	r.tracks = append(r.tracks, tr)
+	r.add(tr, []byte{0x90, 60, 100}, r.start)
+
+	file := filepath.Join(dir, "take.mid")
+	err = r.WriteFile(file)
+	var errs rtmididrv.PortErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0] != errStop {
+		t.Errorf("expected the error of StopListening, got %v", err)
+	}
+
+	f, err := smf.ReadFile(file)
+	if err != nil {
+		t.Fatalf("the file should be written anyway: %v", err)
+	}
+	if len(f.Tracks) != 1 {
+		t.Errorf("got %v tracks", len(f.Tracks))
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the mode of other files, after the umask has been applied
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.Stat(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drv, _ := rtmididrv.New()
+	r, err := New(drv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "take.mid")
+	if err := r.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode() != want.Mode() {
+		t.Errorf("mode %v, want %v", got.Mode(), want.Mode())
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("temporary file left: %v entries", len(entries))
+	}
+}
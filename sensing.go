@@ -0,0 +1,223 @@
+package rtmididrv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ActiveSensingTimeout is the time without messages after which a device that sends active sensing
+// counts as disconnected, as defined by the MIDI specification.
+const ActiveSensingTimeout = 300 * time.Millisecond
+
+// DefaultActiveSensingInterval is the recommended interval for Out.SendActiveSensing. It leaves a margin
+// to the timeout of the receivers.
+const DefaultActiveSensingInterval = 250 * time.Millisecond
+
+var activeSensing = []byte{0xFE}
+
+// SensingEventType is the type of a SensingEvent.
+type SensingEventType int
+
+const (
+	// SensingStarted is sent for the first active sensing message of a port.
+	SensingStarted SensingEventType = iota
+	// ConnectionLost is sent when a port that got active sensing has received no message within ActiveSensingTimeout.
+	ConnectionLost
+	// ConnectionRestored is sent for the first active sensing message after the connection has been lost.
+	ConnectionRestored
+)
+
+var sensingEventTypeNames = [...]string{"sensing_started", "connection_lost", "connection_restored"}
+
+func (t SensingEventType) String() string {
+	if t < 0 || int(t) >= len(sensingEventTypeNames) {
+		return fmt.Sprintf("SensingEventType(%d)", int(t))
+	}
+	return sensingEventTypeNames[t]
+}
+
+// SensingEvent is passed to the handler of ActiveSensing.
+type SensingEvent struct {
+	// Port is the name of the MIDI in port
+	Port string
+	Type SensingEventType
+}
+
+// ActiveSensing lets the MIDI in ports supervise active sensing and pass the changes of the connection state
+// to the handler. Once a port has received active sensing, it expects a message at least every
+// ActiveSensingTimeout; otherwise the connection counts as lost until active sensing arrives again.
+// Ports are only supervised while they have a listener.
+// Active sensing is received even if IgnoreTypes ignores it, but it is then not passed to the listeners.
+// The handler is called on the native input thread of rtmidi for SensingStarted and ConnectionRestored and on a
+// timer goroutine for ConnectionLost. It must not block, since it holds up the incoming messages of the port.
+// The events of a port are passed one at a time and in the order of the state changes; the handler may be called
+// concurrently for different ports.
+func ActiveSensing(handler func(SensingEvent)) Option {
+	return func(d *Driver) {
+		d.sensingHandler = handler
+	}
+}
+
+// sensor supervises the active sensing of a MIDI in port.
+type sensor struct {
+	port    string
+	timeout time.Duration
+	handler func(SensingEvent)
+
+	// delivering serializes the calls of the handler, so that events don't overtake each other
+	delivering sync.Mutex
+
+	sync.Mutex
+	// events are the events waiting for the handler
+	events []SensingEvent
+	// active is set from the first active sensing until the connection is lost
+	active bool
+	lost   bool
+	last   time.Time
+	timer  *time.Timer
+	closed bool
+}
+
+func newSensor(port string, timeout time.Duration, handler func(SensingEvent)) *sensor {
+	return &sensor{port: port, timeout: timeout, handler: handler}
+}
+
+// seen is called for every incoming message.
+func (s *sensor) seen(data []byte) {
+	s.Lock()
+
+	if s.closed {
+		s.Unlock()
+		return
+	}
+
+	isSensing := len(data) == 1 && data[0] == 0xFE
+	if !s.active && !isSensing {
+		s.Unlock()
+		return
+	}
+
+	s.last = time.Now()
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.timeout, s.check)
+	} else {
+		s.timer.Reset(s.timeout)
+	}
+
+	if s.active {
+		s.Unlock()
+		return
+	}
+
+	s.active = true
+	typ := SensingStarted
+	if s.lost {
+		typ = ConnectionRestored
+		s.lost = false
+	}
+	s.events = append(s.events, SensingEvent{Port: s.port, Type: typ})
+	s.Unlock()
+
+	s.deliver()
+}
+
+// check is called by the timer when no message has arrived within the timeout.
+func (s *sensor) check() {
+	s.Lock()
+	if s.closed || !s.active || time.Since(s.last) < s.timeout {
+		s.Unlock()
+		return
+	}
+	s.active = false
+	s.lost = true
+	s.events = append(s.events, SensingEvent{Port: s.port, Type: ConnectionLost})
+	s.Unlock()
+
+	s.deliver()
+}
+
+// deliver passes the waiting events to the handler. The events are queued under the lock, in the order of the
+// state changes, and passed under delivering, so that an event can't overtake an earlier one.
+func (s *sensor) deliver() {
+	s.delivering.Lock()
+	defer s.delivering.Unlock()
+
+	for {
+		s.Lock()
+		events := s.events
+		s.events = nil
+		s.Unlock()
+
+		if len(events) == 0 {
+			return
+		}
+		for _, ev := range events {
+			s.handler(ev)
+		}
+	}
+}
+
+// stop stops the supervision.
+func (s *sensor) stop() {
+	s.Lock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.Unlock()
+}
+
+// emitSensing sends active sensing, whenever nothing has been sent to the port for the interval,
+// until stop is closed or the port is released.
+func (c *outConn) emitSensing(interval time.Duration, stop chan struct{}) {
+	t := time.NewTimer(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		c.Lock()
+		if c.midiOut == nil {
+			c.Unlock()
+			return
+		}
+		next := interval - time.Since(c.lastSent)
+		var err error
+		if next <= 0 {
+			err = c.sendLocked(activeSensing)
+			next = interval
+		}
+		c.Unlock()
+
+		if err != nil {
+			c.driver.reportError(fmt.Errorf("can't send active sensing to MIDI out port %v (%s): %v", c.key.number, c.key.name, err))
+		}
+		t.Reset(next)
+	}
+}
+
+// setSensing starts sending active sensing with the given interval. An interval <= 0 stops it.
+func (c *outConn) setSensing(interval time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stopSensing()
+	if interval <= 0 || c.midiOut == nil {
+		return
+	}
+	c.sensingStop = make(chan struct{})
+	go c.emitSensing(interval, c.sensingStop)
+}
+
+// stopSensing stops sending active sensing. It must be called with the lock held.
+func (c *outConn) stopSensing() {
+	if c.sensingStop != nil {
+		close(c.sensingStop)
+		c.sensingStop = nil
+	}
+}
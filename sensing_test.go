@@ -0,0 +1,141 @@
+package rtmididrv
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSensor(t *testing.T) {
+	var mx sync.Mutex
+	var events []SensingEventType
+	s := newSensor("test", 100*time.Millisecond, func(ev SensingEvent) {
+		mx.Lock()
+		events = append(events, ev.Type)
+		mx.Unlock()
+	})
+	defer s.stop()
+
+	got := func() []SensingEventType {
+		mx.Lock()
+		defer mx.Unlock()
+		return append([]SensingEventType(nil), events...)
+	}
+
+	// no supervision before the first active sensing
+	s.seen([]byte{0x90, 60, 100})
+	time.Sleep(60 * time.Millisecond)
+	if len(got()) != 0 {
+		t.Fatalf("events without active sensing: %v", got())
+	}
+
+	s.seen(activeSensing)
+	for i := 0; i < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		s.seen([]byte{0x80, 60, 0})
+	}
+	if ev := got(); len(ev) != 1 || ev[0] != SensingStarted {
+		t.Fatalf("while receiving: %v", ev)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(got()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// other messages don't restore the connection
+	s.seen([]byte{0x90, 60, 100})
+	s.seen(activeSensing)
+
+	expected := []SensingEventType{SensingStarted, ConnectionLost, ConnectionRestored}
+	ev := got()
+	if len(ev) != len(expected) {
+		t.Fatalf("got %v, expected %v", ev, expected)
+	}
+	for i := range ev {
+		if ev[i] != expected[i] {
+			t.Errorf("got %v, expected %v", ev, expected)
+		}
+	}
+}
+
+func TestSensorOrder(t *testing.T) {
+	var mx sync.Mutex
+	var events []SensingEventType
+	lost := make(chan struct{})
+	var once sync.Once
+	s := newSensor("test", 20*time.Millisecond, func(ev SensingEvent) {
+		if ev.Type == ConnectionLost {
+			// a slow handler on the timer goroutine must not be overtaken by the restored connection
+			once.Do(func() {
+				close(lost)
+				time.Sleep(50 * time.Millisecond)
+			})
+		}
+		mx.Lock()
+		events = append(events, ev.Type)
+		mx.Unlock()
+	})
+	defer s.stop()
+
+	s.seen(activeSensing)
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not lost")
+	}
+	s.seen(activeSensing)
+
+	mx.Lock()
+	defer mx.Unlock()
+	// the connection may be lost again afterwards
+	expected := []SensingEventType{SensingStarted, ConnectionLost, ConnectionRestored}
+	if len(events) < len(expected) {
+		t.Fatalf("got %v, expected %v", events, expected)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("got %v, expected %v", events, expected)
+		}
+	}
+}
+
+func TestCallbackActiveSensing(t *testing.T) {
+	events := make(chan SensingEvent, 10)
+	d, _ := New(ActiveSensing(func(ev SensingEvent) { events <- ev }))
+	f := newFakeMIDI()
+	i := openIn(d, 0, "test", f)
+	defer i.Close()
+
+	received := make(chan []byte, 10)
+	blocked := make(chan struct{})
+	defer close(blocked)
+	err := i.SetListener(func(data []byte, _ int64) {
+		received <- data
+		<-blocked
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the listener blocks the dispatch of the queue, but not the supervision
+	f.receive(t, []byte{0x90, 60, 100})
+	<-received
+	f.receive(t, activeSensing)
+
+	select {
+	case ev := <-events:
+		if ev.Type != SensingStarted || ev.Port != "test" {
+			t.Errorf("event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("active sensing has not been seen")
+	}
+
+	blocked <- struct{}{}
+	select {
+	case data := <-received:
+		t.Errorf("active sensing should not be passed to the listeners, if it is ignored: % X", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,194 @@
+package smf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ErrInvalid is returned (wrapped) by Read when the data is not a valid Standard MIDI File.
+var ErrInvalid = errors.New("invalid SMF")
+
+// ReadFile reads the Standard MIDI File with the given name.
+func ReadFile(file string) (*File, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Read(bytes.NewReader(data))
+}
+
+// Read reads a Standard MIDI File of format 0, 1 or 2 with a resolution in ticks per quarter note.
+// Running status is resolved, so that every channel message has its status byte. SysEx messages are
+// returned with F0 and the complete payload; F7 escape sequences are returned as their plain data.
+// Unknown chunks are skipped.
+func Read(r io.Reader) (*File, error) {
+	br := bufio.NewReader(r)
+
+	typ, data, err := readChunk(br)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "MThd" || len(data) < 6 {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalid)
+	}
+
+	f := &File{
+		Format: int(binary.BigEndian.Uint16(data[0:2])),
+		PPQ:    int(binary.BigEndian.Uint16(data[4:6])),
+	}
+	ntracks := int(binary.BigEndian.Uint16(data[2:4]))
+
+	if f.Format > 2 {
+		return nil, fmt.Errorf("%w: unknown format %v", ErrInvalid, f.Format)
+	}
+	if f.PPQ&0x8000 != 0 {
+		return nil, fmt.Errorf("can't read SMF: SMPTE time division is not supported")
+	}
+	if f.PPQ == 0 {
+		return nil, fmt.Errorf("%w: PPQ is 0", ErrInvalid)
+	}
+
+	for len(f.Tracks) < ntracks {
+		typ, data, err = readChunk(br)
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: %v of %v tracks", ErrInvalid, len(f.Tracks), ntracks)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if typ != "MTrk" {
+			continue
+		}
+
+		t, err := parseTrack(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: track %v: %v", ErrInvalid, len(f.Tracks), err)
+		}
+		f.Tracks = append(f.Tracks, t)
+	}
+
+	return f, nil
+}
+
+func readChunk(r io.Reader) (typ string, data []byte, err error) {
+	var head [8]byte
+	_, err = io.ReadFull(r, head[:])
+	if err == io.EOF {
+		return "", nil, io.EOF
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: truncated chunk header", ErrInvalid)
+	}
+
+	n := binary.BigEndian.Uint32(head[4:])
+	if n > 1<<28 {
+		return "", nil, fmt.Errorf("%w: chunk of %v bytes", ErrInvalid, n)
+	}
+
+	data = make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: truncated %s chunk", ErrInvalid, head[:4])
+	}
+	return string(head[:4]), data, nil
+}
+
+func parseTrack(data []byte) (Track, error) {
+	var (
+		t       Track
+		running byte
+		pos     int
+	)
+
+	for pos < len(data) {
+		delta, n, err := readVarLen(data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+
+		if pos >= len(data) {
+			return nil, fmt.Errorf("missing event at offset %v", pos)
+		}
+
+		status := data[pos]
+		var ev []byte
+
+		switch {
+		case status == 0xFF:
+			if pos+2 > len(data) {
+				return nil, fmt.Errorf("truncated meta event at offset %v", pos)
+			}
+			l, n, err := readVarLen(data[pos+2:])
+			if err != nil {
+				return nil, err
+			}
+			start := pos + 2 + n
+			if start+int(l) > len(data) {
+				return nil, fmt.Errorf("truncated meta event at offset %v", pos)
+			}
+			ev = Meta(data[pos+1], data[start:start+int(l)])
+			pos = start + int(l)
+			running = 0
+		case status == 0xF0 || status == 0xF7:
+			l, n, err := readVarLen(data[pos+1:])
+			if err != nil {
+				return nil, err
+			}
+			start := pos + 1 + n
+			if start+int(l) > len(data) {
+				return nil, fmt.Errorf("truncated SysEx at offset %v", pos)
+			}
+			if status == 0xF0 {
+				ev = append([]byte{0xF0}, data[start:start+int(l)]...)
+			} else {
+				ev = append([]byte(nil), data[start:start+int(l)]...)
+			}
+			pos = start + int(l)
+			running = 0
+		case status >= 0x80:
+			if status > 0xF0 {
+				return nil, fmt.Errorf("invalid status % X at offset %v", status, pos)
+			}
+			running = status
+			pos++
+			fallthrough
+		default:
+			if running == 0 {
+				return nil, fmt.Errorf("data byte without status at offset %v", pos)
+			}
+			l := channelMessageLen(running) - 1
+			if pos+l > len(data) {
+				return nil, fmt.Errorf("truncated message at offset %v", pos)
+			}
+			ev = append([]byte{running}, data[pos:pos+l]...)
+			pos += l
+		}
+
+		t = append(t, Event{Delta: delta, Data: ev})
+
+		if typ, _, ok := IsMeta(ev); ok && typ == MetaEndOfTrack {
+			break
+		}
+	}
+
+	return t, nil
+}
+
+// readVarLen reads a variable-length quantity and returns it with the number of bytes read.
+func readVarLen(data []byte) (v uint32, n int, err error) {
+	for n < len(data) && n < 4 {
+		b := data[n]
+		n++
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return v, n, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid variable-length quantity")
+}
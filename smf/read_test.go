@@ -0,0 +1,101 @@
+package smf
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	f := &File{
+		Format: 1,
+		PPQ:    480,
+		Tracks: []Track{
+			{
+				{Data: Tempo(600000)},
+				{Data: TimeSignature(6, 8)},
+				{Data: EndOfTrack()},
+			},
+			{
+				{Data: Port(1)},
+				{Data: []byte{0x90, 60, 100}},
+				{Delta: 480, Data: []byte{0x90, 64, 100}},
+				{Delta: 200, Data: []byte{0x80, 60, 0}},
+				{Delta: 0, Data: []byte{0xF0, 0x43, 0x10, 0xF7}},
+				{Delta: 1000, Data: []byte{0xC0, 5}},
+				{Delta: 0, Data: []byte{0xF8}},
+				{Data: EndOfTrack()},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("got\n%v\nwant\n%v", got, f)
+	}
+}
+
+func TestReadRunningStatus(t *testing.T) {
+	data := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+		'X', 'Y', 'Z', 'W', 0, 0, 0, 2, 1, 2,
+		'M', 'T', 'r', 'k', 0, 0, 0, 14,
+		0x00, 0x90, 60, 100,
+		0x60, 60, 0,
+		0x00, 0xC1, 3,
+		0x00, 0xFF, 0x2F, 0x00,
+	}
+
+	f, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Track{
+		{Data: []byte{0x90, 60, 100}},
+		{Delta: 96, Data: []byte{0x90, 60, 0}},
+		{Data: []byte{0xC1, 3}},
+		{Data: EndOfTrack()},
+	}
+
+	if len(f.Tracks) != 1 || !reflect.DeepEqual(f.Tracks[0], want) {
+		t.Errorf("got %v, want %v", f.Tracks, want)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := [][]byte{
+		[]byte("RIFF"),
+		{'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96},
+		{'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 3, 0, 1, 0, 96},
+		{
+			'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+			'M', 'T', 'r', 'k', 0, 0, 0, 2, 0x00, 60,
+		},
+		{
+			'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+			'M', 'T', 'r', 'k', 0, 0, 0, 3, 0x00, 0x90, 60,
+		},
+		{
+			'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+			'M', 'T', 'r', 'k', 0, 0, 0, 9, 0x00, 0x90,
+		},
+	}
+
+	for i, data := range tests {
+		_, err := Read(bytes.NewReader(data))
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("[%v] expected ErrInvalid, got %v", i, err)
+		}
+	}
+}
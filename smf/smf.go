@@ -0,0 +1,86 @@
+// Package smf reads and writes Standard MIDI Files.
+//
+// The events of a track have the delta time in ticks to the previous event and their data:
+// MIDI messages are stored as they are sent over the wire (SysEx messages start with F0 and end with F7),
+// meta events as FF, their type and their payload (without the length). Any other data, e.g.
+// realtime messages, is stored in the file as F7 escape sequences.
+package smf
+
+// File is a Standard MIDI File.
+type File struct {
+	// Format is 0 (a single track), 1 (simultaneous tracks) or 2 (independent tracks).
+	Format int
+	// PPQ is the resolution in ticks per quarter note.
+	PPQ int
+	// Tracks are the tracks of the file.
+	Tracks []Track
+}
+
+// Track is a track of a Standard MIDI File.
+type Track []Event
+
+// Event is an event of a track.
+type Event struct {
+	// Delta is the time in ticks since the previous event of the track.
+	Delta uint32
+	// Data is the MIDI message or meta event.
+	Data []byte
+}
+
+// Meta event types.
+const (
+	MetaText          = 0x01
+	MetaTrackName     = 0x03
+	MetaPort          = 0x21
+	MetaEndOfTrack    = 0x2F
+	MetaTempo         = 0x51
+	MetaTimeSignature = 0x58
+)
+
+// DefaultTempo is the tempo in microseconds per quarter note that applies before the first tempo event (120 BPM).
+const DefaultTempo = 500000
+
+// Meta returns the data of a meta event.
+func Meta(typ byte, payload []byte) []byte {
+	return append([]byte{0xFF, typ}, payload...)
+}
+
+// Tempo returns a tempo meta event with the given microseconds per quarter note.
+func Tempo(microsecondsPerQuarter uint32) []byte {
+	t := microsecondsPerQuarter
+	return Meta(MetaTempo, []byte{byte(t >> 16), byte(t >> 8), byte(t)})
+}
+
+// TrackName returns a track name meta event.
+func TrackName(name string) []byte {
+	return Meta(MetaTrackName, []byte(name))
+}
+
+// EndOfTrack returns the end of track meta event.
+func EndOfTrack() []byte {
+	return Meta(MetaEndOfTrack, nil)
+}
+
+// IsMeta reports whether the data is a meta event and returns its type and payload.
+func IsMeta(data []byte) (typ byte, payload []byte, ok bool) {
+	if len(data) < 2 || data[0] != 0xFF {
+		return 0, nil, false
+	}
+	return data[1], data[2:], true
+}
+
+// TimeSignature returns a time signature meta event, e.g. TimeSignature(6, 8) for 6/8.
+// The denominator must be a power of 2. The event has 24 MIDI clocks per metronome click
+// and 8 32nd notes per quarter note.
+func TimeSignature(numerator, denominator uint8) []byte {
+	var pow byte
+	for d := denominator; d > 1; d >>= 1 {
+		pow++
+	}
+	return Meta(MetaTimeSignature, []byte{numerator, pow, 24, 8})
+}
+
+// Port returns a MIDI port meta event, that assigns the track to the given port of a multi-port file.
+func Port(port uint8) []byte {
+	return Meta(MetaPort, []byte{port})
+}
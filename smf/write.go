@@ -0,0 +1,120 @@
+package smf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// WriteTo writes the file to w. An end of track event is added to tracks that have none.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if f.Format < 0 || f.Format > 2 || f.Format == 0 && len(f.Tracks) != 1 {
+		return 0, fmt.Errorf("can't write SMF: format %v with %v tracks", f.Format, len(f.Tracks))
+	}
+
+	if f.PPQ <= 0 || f.PPQ > 0x7FFF {
+		return 0, fmt.Errorf("can't write SMF: invalid PPQ %v", f.PPQ)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("MThd")
+	binary.Write(&buf, binary.BigEndian, uint32(6))
+	binary.Write(&buf, binary.BigEndian, [3]uint16{uint16(f.Format), uint16(len(f.Tracks)), uint16(f.PPQ)})
+
+	for i, t := range f.Tracks {
+		err := t.write(&buf)
+		if err != nil {
+			return 0, fmt.Errorf("can't write SMF track %v: %v", i, err)
+		}
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func (t Track) write(buf *bytes.Buffer) error {
+	var data bytes.Buffer
+
+	ended := false
+	for _, ev := range t {
+		if ended {
+			return fmt.Errorf("event after end of track")
+		}
+
+		err := writeEvent(&data, ev)
+		if err != nil {
+			return err
+		}
+
+		if typ, _, ok := IsMeta(ev.Data); ok && typ == MetaEndOfTrack {
+			ended = true
+		}
+	}
+
+	if !ended {
+		writeEvent(&data, Event{Data: EndOfTrack()})
+	}
+
+	buf.WriteString("MTrk")
+	binary.Write(buf, binary.BigEndian, uint32(data.Len()))
+	buf.Write(data.Bytes())
+	return nil
+}
+
+func writeEvent(buf *bytes.Buffer, ev Event) error {
+	d := ev.Data
+	if len(d) == 0 {
+		return fmt.Errorf("empty event")
+	}
+
+	writeVarLen(buf, ev.Delta)
+
+	switch {
+	case d[0] >= 0x80 && d[0] < 0xF0:
+		if len(d) != channelMessageLen(d[0]) {
+			return fmt.Errorf("invalid channel message % X", d)
+		}
+		buf.Write(d)
+	case d[0] == 0xF0:
+		buf.WriteByte(0xF0)
+		writeVarLen(buf, uint32(len(d)-1))
+		buf.Write(d[1:])
+	case d[0] == 0xFF:
+		if len(d) < 2 {
+			return fmt.Errorf("invalid meta event % X", d)
+		}
+		buf.Write(d[:2])
+		writeVarLen(buf, uint32(len(d)-2))
+		buf.Write(d[2:])
+	default:
+		// escape sequence
+		buf.WriteByte(0xF7)
+		writeVarLen(buf, uint32(len(d)))
+		buf.Write(d)
+	}
+
+	return nil
+}
+
+// channelMessageLen returns the length of a channel message with the given status.
+func channelMessageLen(status byte) int {
+	switch status & 0xF0 {
+	case 0xC0, 0xD0:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// writeVarLen writes a variable-length quantity.
+func writeVarLen(buf *bytes.Buffer, v uint32) {
+	var b [5]byte
+	i := len(b) - 1
+	b[i] = byte(v & 0x7F)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		b[i] = byte(v&0x7F) | 0x80
+	}
+	buf.Write(b[i:])
+}
@@ -0,0 +1,58 @@
+package smf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	f := &File{
+		Format: 0,
+		PPQ:    96,
+		Tracks: []Track{{
+			{Data: Tempo(500000)},
+			{Delta: 0, Data: []byte{0x90, 60, 100}},
+			{Delta: 200, Data: []byte{0x80, 60, 0}},
+			{Delta: 0, Data: []byte{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}},
+			{Delta: 0, Data: []byte{0xF8}},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+		'M', 'T', 'r', 'k', 0, 0, 0, 32,
+		0x00, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20,
+		0x00, 0x90, 60, 100,
+		0x81, 0x48, 0x80, 60, 0,
+		0x00, 0xF0, 0x05, 0x7E, 0x7F, 0x09, 0x01, 0xF7,
+		0x00, 0xF7, 0x01, 0xF8,
+		0x00, 0xFF, 0x2F, 0x00,
+	}
+
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n% X\nwant\n% X", buf.Bytes(), want)
+	}
+}
+
+func TestWriteVarLen(t *testing.T) {
+	tests := map[uint32][]byte{
+		0:          {0x00},
+		0x7F:       {0x7F},
+		0x80:       {0x81, 0x00},
+		0x3FFF:     {0xFF, 0x7F},
+		0x0FFFFFFF: {0xFF, 0xFF, 0xFF, 0x7F},
+	}
+
+	for v, want := range tests {
+		var buf bytes.Buffer
+		writeVarLen(&buf, v)
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("writeVarLen(%X) = % X, want % X", v, buf.Bytes(), want)
+		}
+	}
+}
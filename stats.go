@@ -0,0 +1,257 @@
+package rtmididrv
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StatusType is the type of a MIDI message, as far as it is told by its status byte.
+type StatusType int
+
+// The status types of MIDI messages.
+const (
+	StatusNoteOff StatusType = iota
+	StatusNoteOn
+	StatusPolyAftertouch
+	StatusControlChange
+	StatusProgramChange
+	StatusAftertouch
+	StatusPitchBend
+	StatusSysEx
+	StatusSystemCommon
+	StatusRealtime
+	// StatusOther is the type of messages without a valid status byte.
+	StatusOther
+
+	numStatusTypes = int(StatusOther) + 1
+)
+
+var statusTypeNames = [numStatusTypes]string{
+	"note_off",
+	"note_on",
+	"poly_aftertouch",
+	"control_change",
+	"program_change",
+	"aftertouch",
+	"pitch_bend",
+	"sysex",
+	"system_common",
+	"realtime",
+	"other",
+}
+
+// String returns the name of the status type, as used in the exported metrics.
+func (s StatusType) String() string {
+	if s < 0 || int(s) >= numStatusTypes {
+		return "other"
+	}
+	return statusTypeNames[s]
+}
+
+// MarshalText returns the name of the status type, so that it can be used as a key in JSON.
+func (s StatusType) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// StatusTypeOf returns the status type of the given message.
+func StatusTypeOf(msg []byte) StatusType {
+	if len(msg) == 0 {
+		return StatusOther
+	}
+	b := msg[0]
+	switch {
+	case b < 0x80:
+		return StatusOther
+	case b < 0xF0:
+		return StatusType(b>>4) - 8
+	case b == 0xF0 || b == 0xF7:
+		return StatusSysEx
+	case b < 0xF8:
+		return StatusSystemCommon
+	default:
+		return StatusRealtime
+	}
+}
+
+// Stats are the traffic counters of a MIDI port. They are shared by all handles of the port.
+type Stats struct {
+	// Port is the name of the port
+	Port string
+	// Number is the number of the port
+	Number int
+	// Direction is "in" or "out"
+	Direction string
+
+	// Messages and Bytes count the messages received (including dropped ones) or sent by status type.
+	Messages map[StatusType]uint64
+	Bytes    map[StatusType]uint64
+
+	// Errors counts the messages that could not be sent and the panics of listeners.
+	Errors uint64
+	// Dropped counts the incoming messages that were dropped, because the queue of the port was full.
+	Dropped uint64
+	// SlowListeners counts the listener calls that exceeded the listener budget.
+	SlowListeners uint64
+	// QueueHighWater is the highest number of messages that waited in the queue of the port.
+	QueueHighWater uint64
+
+	// LastActivity is the time of the last message (zero if there was none).
+	LastActivity time.Time
+}
+
+// portStats holds the counters of a port. All fields are accessed atomically.
+type portStats struct {
+	messages       [numStatusTypes]uint64
+	bytes          [numStatusTypes]uint64
+	errors         uint64
+	dropped        uint64
+	slowListeners  uint64
+	queueHighWater uint64
+	// lastActivity is in nanoseconds since the unix epoch
+	lastActivity int64
+}
+
+// count counts the given message.
+func (s *portStats) count(msg []byte) {
+	t := StatusTypeOf(msg)
+	atomic.AddUint64(&s.messages[t], 1)
+	atomic.AddUint64(&s.bytes[t], uint64(len(msg)))
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+// queued records the number of messages waiting in a queue. It must only be called by the producer of the queue.
+func (s *portStats) queued(n uint64) {
+	if n > atomic.LoadUint64(&s.queueHighWater) {
+		atomic.StoreUint64(&s.queueHighWater, n)
+	}
+}
+
+func (s *portStats) snapshot(direction string, key portKey) Stats {
+	st := Stats{
+		Port:           key.name,
+		Number:         key.number,
+		Direction:      direction,
+		Messages:       map[StatusType]uint64{},
+		Bytes:          map[StatusType]uint64{},
+		Errors:         atomic.LoadUint64(&s.errors),
+		Dropped:        atomic.LoadUint64(&s.dropped),
+		SlowListeners:  atomic.LoadUint64(&s.slowListeners),
+		QueueHighWater: atomic.LoadUint64(&s.queueHighWater),
+	}
+
+	for t := 0; t < numStatusTypes; t++ {
+		if n := atomic.LoadUint64(&s.messages[t]); n > 0 {
+			st.Messages[StatusType(t)] = n
+			st.Bytes[StatusType(t)] = atomic.LoadUint64(&s.bytes[t])
+		}
+	}
+
+	if last := atomic.LoadInt64(&s.lastActivity); last > 0 {
+		st.LastActivity = time.Unix(0, last)
+	}
+
+	return st
+}
+
+// Stats returns the traffic counters of all open ports, in ports first, ordered by number.
+// The counters of a port start at zero whenever it is opened after all of its handles had been closed.
+func (d *Driver) Stats() []Stats {
+	d.RLock()
+	var stats []Stats
+	for k, c := range d.inConns {
+		if atomic.LoadInt32(&c.acquired) == 1 {
+			stats = append(stats, c.stats.snapshot("in", k))
+		}
+	}
+	for k, c := range d.outConns {
+		if atomic.LoadInt32(&c.acquired) == 1 {
+			stats = append(stats, c.stats.snapshot("out", k))
+		}
+	}
+	d.RUnlock()
+
+	sort.Slice(stats, func(a, b int) bool {
+		if stats[a].Direction != stats[b].Direction {
+			return stats[a].Direction == "in"
+		}
+		if stats[a].Number != stats[b].Number {
+			return stats[a].Number < stats[b].Number
+		}
+		return stats[a].Port < stats[b].Port
+	})
+
+	return stats
+}
+
+// Publish exports the result of Stats as expvar variable with the given name.
+// Like expvar.Publish, it panics if the name is already in use.
+func (d *Driver) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return d.Stats()
+	}))
+}
+
+// WritePrometheus writes the result of Stats to w in the Prometheus text exposition format.
+func (d *Driver) WritePrometheus(w io.Writer) error {
+	stats := d.Stats()
+
+	var b strings.Builder
+
+	metric := func(name, typ, help string, value func(s Stats, labels string)) {
+		fmt.Fprintf(&b, "# HELP rtmididrv_%s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE rtmididrv_%s %s\n", name, typ)
+		for _, s := range stats {
+			value(s, fmt.Sprintf(`direction="%s",number="%d",port="%s"`, s.Direction, s.Number, escapeLabel(s.Port)))
+		}
+	}
+
+	byType := func(name string, counts func(Stats) map[StatusType]uint64) func(Stats, string) {
+		return func(s Stats, labels string) {
+			for t := 0; t < numStatusTypes; t++ {
+				if n, has := counts(s)[StatusType(t)]; has {
+					fmt.Fprintf(&b, "rtmididrv_%s{%s,type=\"%s\"} %d\n", name, labels, StatusType(t), n)
+				}
+			}
+		}
+	}
+
+	single := func(name string, value func(Stats) uint64) func(Stats, string) {
+		return func(s Stats, labels string) {
+			fmt.Fprintf(&b, "rtmididrv_%s{%s} %d\n", name, labels, value(s))
+		}
+	}
+
+	metric("messages_total", "counter", "MIDI messages received or sent by status type.",
+		byType("messages_total", func(s Stats) map[StatusType]uint64 { return s.Messages }))
+	metric("bytes_total", "counter", "MIDI bytes received or sent by status type.",
+		byType("bytes_total", func(s Stats) map[StatusType]uint64 { return s.Bytes }))
+	metric("errors_total", "counter", "Failed sends and panics of listeners.",
+		single("errors_total", func(s Stats) uint64 { return s.Errors }))
+	metric("dropped_total", "counter", "Incoming MIDI messages dropped because the queue was full.",
+		single("dropped_total", func(s Stats) uint64 { return s.Dropped }))
+	metric("slow_listeners_total", "counter", "Listener calls that exceeded the listener budget.",
+		single("slow_listeners_total", func(s Stats) uint64 { return s.SlowListeners }))
+	metric("queue_high_water", "gauge", "Highest number of incoming MIDI messages waiting in the queue.",
+		single("queue_high_water", func(s Stats) uint64 { return s.QueueHighWater }))
+	metric("last_activity_seconds", "gauge", "Unix time of the last MIDI message.",
+		func(s Stats, labels string) {
+			var secs float64
+			if !s.LastActivity.IsZero() {
+				secs = float64(s.LastActivity.UnixNano()) / 1e9
+			}
+			fmt.Fprintf(&b, "rtmididrv_last_activity_seconds{%s} %.3f\n", labels, secs)
+		})
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,80 @@
+package rtmididrv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatusTypeOf(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		want StatusType
+	}{
+		{nil, StatusOther},
+		{[]byte{0x40}, StatusOther},
+		{[]byte{0x80, 60, 0}, StatusNoteOff},
+		{[]byte{0x93, 60, 100}, StatusNoteOn},
+		{[]byte{0xB0, 7, 100}, StatusControlChange},
+		{[]byte{0xEF, 0, 64}, StatusPitchBend},
+		{[]byte{0xF0, 0x7E, 0xF7}, StatusSysEx},
+		{[]byte{0xF2, 0, 0}, StatusSystemCommon},
+		{[]byte{0xF8}, StatusRealtime},
+	}
+
+	for _, test := range tests {
+		if got := StatusTypeOf(test.msg); got != test.want {
+			t.Errorf("StatusTypeOf(% X) = %v, want %v", test.msg, got, test.want)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	d, _ := New()
+	c := openOut(d, 1, `Synth "A"`, newFakeMIDI()).conn
+	c.stats.count([]byte{0x90, 60, 100})
+	c.stats.count([]byte{0x80, 60, 0})
+	c.stats.count([]byte{0x90, 62, 100})
+
+	var buf bytes.Buffer
+	if err := d.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# TYPE rtmididrv_messages_total counter\n",
+		`rtmididrv_messages_total{direction="out",number="1",port="Synth \"A\"",type="note_on"} 2` + "\n",
+		`rtmididrv_bytes_total{direction="out",number="1",port="Synth \"A\"",type="note_off"} 3` + "\n",
+		`rtmididrv_dropped_total{direction="out",number="1",port="Synth \"A\""} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestDriverStats(t *testing.T) {
+	d, _ := New()
+
+	// handles of enumerated ports that are never opened have no shared connection
+	newIn(false, d, 2, "Unused")
+	newOut(false, d, 2, "Unused")
+
+	in := openIn(d, 0, "Keyboard", newFakeMIDI())
+	out := openOut(d, 0, "Synth", newFakeMIDI())
+
+	stats := d.Stats()
+	if len(stats) != 2 || stats[0].Direction != "in" || stats[0].Port != "Keyboard" || stats[1].Port != "Synth" {
+		t.Fatalf("stats of open ports: %+v", stats)
+	}
+
+	in.Close()
+	out.Close()
+
+	if stats := d.Stats(); len(stats) != 0 {
+		t.Errorf("stats after closing: %+v", stats)
+	}
+	if len(d.inConns) != 0 || len(d.outConns) != 0 {
+		t.Errorf("unreferenced connections kept: %v in, %v out", len(d.inConns), len(d.outConns))
+	}
+}
@@ -0,0 +1,102 @@
+package rtmididrv
+
+import (
+	"math"
+	"time"
+
+	"github.com/minikomi/rtmididrv/imported/rtmidi"
+)
+
+// TimestampSource is the source of the timestamps of incoming messages.
+type TimestampSource int
+
+const (
+	// TimestampBackend derives the timestamps from the deltas that the native MIDI API reports.
+	// The first message of a port is placed on the clock of the driver, when it arrives in Go;
+	// the following messages keep the distances that the native API has measured.
+	TimestampBackend TimestampSource = iota
+
+	// TimestampGoClock takes the timestamps from the clock of the driver, when the messages arrive in Go.
+	TimestampGoClock
+
+	// TimestampNative uses the time stamps that the native MIDI API has given to the events, e.g. the
+	// real-time of the ALSA sequencer, so that the timing reflects when the API received the events.
+	// The first message of a port is placed on the clock of the driver, when it arrives in Go;
+	// the following messages keep their distances on the clock of the API.
+	// If the API does not provide time stamps (see rtmidi.MIDIIn.SourceTime), TimestampBackend is used instead.
+	TimestampNative
+)
+
+// Now returns the current time of the driver in microseconds. The time is measured on a monotonic clock
+// since the driver has been created. It is the time base of the timestamps of all ports of the driver.
+func (d *Driver) Now() int64 {
+	return int64(time.Since(d.epoch) / time.Microsecond)
+}
+
+// timestamper computes the timestamps of the messages of a port while a callback is set.
+// It must only be used by the native input thread.
+type timestamper struct {
+	driver *Driver
+
+	// prev is the timestamp of the previous message, or the time when the listening started
+	prev int64
+
+	// anchor is the driver time of the first message, seconds the sum of the native deltas since then
+	anchored bool
+	anchor   int64
+	seconds  float64
+
+	// firstSource is the native time stamp of the first message, if the source is TimestampNative
+	firstSource float64
+}
+
+func newTimestamper(d *Driver) *timestamper {
+	return &timestamper{driver: d, prev: d.Now()}
+}
+
+// stamp returns the timestamp of the message and the distance to the previous message in microseconds.
+// The delta of the first message is the time since the listening started.
+// m is the MIDIIn that passes the message to the callback.
+func (t *timestamper) stamp(m rtmidi.MIDIIn, deltaSeconds float64) (timestampMicroseconds, deltaMicroseconds int64) {
+	source := t.driver.timestampSource
+
+	var sourceSeconds float64
+	if source == TimestampNative {
+		var ok bool
+		sourceSeconds, ok = m.SourceTime()
+		if !ok {
+			source = TimestampBackend
+		}
+	}
+
+	switch source {
+	case TimestampGoClock:
+		timestampMicroseconds = t.driver.Now()
+	case TimestampNative:
+		if !t.anchored {
+			t.anchored = true
+			t.anchor = t.driver.Now()
+			t.firstSource = sourceSeconds
+		}
+		timestampMicroseconds = t.anchor + int64(math.Round((sourceSeconds-t.firstSource)*1000000))
+	default:
+		// the native API reports 0 for the first message
+		if !t.anchored {
+			t.anchored = true
+			t.anchor = t.driver.Now()
+		} else {
+			// summing up the seconds instead of rounded microseconds prevents rounding errors from accumulating
+			t.seconds += deltaSeconds
+		}
+		timestampMicroseconds = t.anchor + int64(math.Round(t.seconds*1000000))
+	}
+
+	// the timestamps must never go backwards
+	if timestampMicroseconds < t.prev {
+		timestampMicroseconds = t.prev
+	}
+
+	deltaMicroseconds = timestampMicroseconds - t.prev
+	t.prev = timestampMicroseconds
+	return
+}
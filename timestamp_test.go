@@ -0,0 +1,29 @@
+package rtmididrv
+
+import (
+	"testing"
+)
+
+func TestTimestamperBackend(t *testing.T) {
+	d, _ := New()
+	ts := newTimestamper(d)
+	start := ts.prev
+
+	abs0, delta0 := ts.stamp(nil, 0)
+	if abs0 < start || delta0 != abs0-start {
+		t.Errorf("first message: timestamp %v, delta %v, listening started at %v", abs0, delta0, start)
+	}
+
+	// 1000 deltas of 0.1ms each would accumulate rounding errors, if rounded one by one
+	var abs, delta int64
+	for i := 0; i < 1000; i++ {
+		abs, delta = ts.stamp(nil, 0.0001004)
+		if delta < 0 {
+			t.Fatalf("negative delta %v", delta)
+		}
+	}
+
+	if got, want := abs-abs0, int64(100400); got != want {
+		t.Errorf("timestamp after 1000 messages: got %v, want %v", got, want)
+	}
+}